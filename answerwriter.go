@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// answerBackend persists batches of answers, e.g. to a database
+type answerBackend interface {
+	insert(answers []Answer) error
+	close()
+}
+
+// answerTicker is a backend with work of its own while idle, e.g. rotating
+// files, on the ticks of the writer
+type answerTicker interface {
+	tick()
+}
+
+// answerWriter is the single writer of a backend, fed by the message handlers
+// of all workers so they do not contend for e.g. the SQLite write lock.
+// Answers are buffered and written in large batches, a batch is flushed when
+// it is full or when its oldest answer has been buffered for the maximum age,
+// so answers on a quiet resolver are not held in memory for long.
+type answerWriter struct {
+	name    string
+	backend answerBackend
+	types   []uint16
+	size    int
+	age     time.Duration
+
+	pipe     chan []Answer
+	answers  []Answer
+	buffered time.Time
+	done     chan struct{}
+
+	// throughput
+	written int
+	elapsed time.Duration
+
+	mutex      *sync.Mutex
+	references int
+}
+
+// write the buffered answers to the backend, retrying for a while (e.g.
+// while the retention holds the SQLite write lock)
+func (this *answerWriter) flush(attempts int) {
+	if 0 < len(this.answers) {
+		for attempt := 0; attempt < attempts; attempt++ {
+			started := time.Now()
+			if e := this.backend.insert(this.answers); e == nil {
+				this.written += len(this.answers)
+				this.elapsed += time.Since(started)
+				this.answers = this.answers[:0]
+				return
+			} else {
+				fmt.Fprintln(os.Stderr, e)
+				time.Sleep(2 * time.Second)
+			}
+		}
+		fatalf("Failed to insert answers into %v database\n", this.name)
+	}
+}
+
+func (this *answerWriter) run() {
+	defer close(this.done)
+
+	// check the age of the buffer a few times per maximum age
+	tick := this.age / 10
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for running := true; running; {
+		select {
+		case answers, ok := <-this.pipe:
+			if !ok {
+				running = false
+				break
+			}
+			if len(this.answers) == 0 {
+				this.buffered = time.Now()
+			}
+			this.answers = append(this.answers, answers...)
+
+			if this.size <= len(this.answers) {
+				this.flush(30)
+			}
+		case <-ticker.C:
+			if 0 < len(this.answers) && this.age <= time.Since(this.buffered) {
+				this.flush(30)
+			}
+			if backend, ok := this.backend.(answerTicker); ok {
+				backend.tick()
+			}
+		}
+	}
+
+	this.flush(8)
+	this.backend.close()
+
+	if 0 < this.elapsed {
+		fmt.Fprintf(os.Stderr, "%v writer inserted %v answer(s) in %v (%.0f answers/s)\n", this.name, this.written, this.elapsed.Round(time.Millisecond), float64(this.written)/this.elapsed.Seconds())
+	}
+}
+
+// pass the answers of the writer's types to the writer goroutine, the answers
+// are shared with the other outputs
+func (this *answerWriter) write(answers []Answer) {
+	if 0 < len(this.types) {
+		kept := make([]Answer, 0, len(answers))
+		for _, answer := range answers {
+			if contains(&this.types, answer.Type) {
+				kept = append(kept, answer)
+			}
+		}
+		answers = kept
+	}
+	if 0 < len(answers) {
+		this.pipe <- answers
+	}
+}
+
+// register a message handler, the writer is closed when the last one is
+func (this *answerWriter) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *answerWriter) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		close(this.pipe)
+		<-this.done
+	}
+}
+
+// the answer types kept by the (passive DNS) stores
+var STORETYPES = []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME}
+
+// create a writer of the answers of the types (none means all) to the backend
+func newAnswerWriter(name string, backend answerBackend, types []uint16, size int, age time.Duration) *answerWriter {
+	this := &answerWriter{name: name, backend: backend, types: types, size: size, age: age, pipe: make(chan []Answer, 1024), answers: make([]Answer, 0, size), done: make(chan struct{}), mutex: new(sync.Mutex)}
+	go this.run()
+
+	return this
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// buckets of the bbolt store, the records are keyed by name, class, type and
+// data, the indexes map the lower cased name, the reversed name (see reverse),
+// the data and the address (of A and AAAA records, see addressKey) to the
+// record keys
+var (
+	BOLTRECORDS   = []byte("records")
+	BOLTNAMES     = []byte("names")
+	BOLTRNAMES    = []byte("rnames")
+	BOLTDATA      = []byte("data")
+	BOLTADDRESSES = []byte("addresses")
+)
+
+// records per transaction, bbolt only splits pages on commit so very large
+// transactions slow down
+const BOLTTRANSACTION = 5000
+
+// wait for the lock of a database written by another process, e.g. while it
+// stops
+const BOLTLOCKTIMEOUT = time.Second
+
+// separates the fields of the keys, does not occur in names or (A, AAAA and CNAME) data
+const BOLTSEPARATOR = "\x00"
+
+func boltKey(fields ...string) []byte {
+	return []byte(strings.Join(fields, BOLTSEPARATOR))
+}
+
+// count, first and last seen (Unix nanoseconds)
+func boltValue(record Record) []byte {
+	value := make([]byte, 24)
+	binary.BigEndian.PutUint64(value[0:], uint64(record.Count))
+	binary.BigEndian.PutUint64(value[8:], uint64(record.FirstSeen.UnixNano()))
+	binary.BigEndian.PutUint64(value[16:], uint64(record.LastSeen.UnixNano()))
+	return value
+}
+
+func boltRecord(key []byte, value []byte) (Record, bool) {
+	fields := strings.SplitN(string(key), BOLTSEPARATOR, 4)
+	if len(fields) != 4 || len(value) != 24 {
+		return Record{}, false
+	}
+	return Record{
+		Name:      fields[0],
+		Class:     fields[1],
+		Type:      fields[2],
+		Data:      fields[3],
+		Count:     int64(binary.BigEndian.Uint64(value[0:])),
+		FirstSeen: time.Unix(0, int64(binary.BigEndian.Uint64(value[8:]))),
+		LastSeen:  time.Unix(0, int64(binary.BigEndian.Uint64(value[16:])))}, true
+}
+
+// boltWriter writes the answers to an embedded bbolt (B+tree) database,
+// aggregated into records like the PostgreSQL store. Each batch is merged in
+// memory first, so every record is read and updated once per transaction.
+type boltWriter struct {
+	database *bolt.DB
+}
+
+func (this *boltWriter) insert(answers []Answer) error {
+	records := aggregateFolded(answers)
+	for 0 < len(records) {
+		chunk := records
+		if BOLTTRANSACTION < len(chunk) {
+			chunk = chunk[:BOLTTRANSACTION]
+		}
+		records = records[len(chunk):]
+
+		if e := this.database.Update(func(transaction *bolt.Tx) error { return this.update(transaction, chunk) }); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// the key of a record in the bucket of an index, nil if not indexed
+func boltIndexKey(index []byte, key []byte, record Record) []byte {
+	switch {
+	case bytes.Equal(index, BOLTNAMES):
+		return boltKey(strings.ToLower(record.Name), record.Class, record.Type, record.Data)
+	case bytes.Equal(index, BOLTRNAMES):
+		return boltKey(reverse(record.Name), record.Class, record.Type, record.Data)
+	case bytes.Equal(index, BOLTDATA):
+		return boltKey(record.Data, record.Name, record.Class, record.Type)
+	case bytes.Equal(index, BOLTADDRESSES):
+		if address := addressKey(record.Type, record.Data); address != nil {
+			return append(address, key...)
+		}
+	}
+	return nil
+}
+
+// index a (new) record in the buckets of the indexes
+func boltIndex(transaction *bolt.Tx, indexes [][]byte, key []byte, record Record) error {
+	for _, index := range indexes {
+		if indexKey := boltIndexKey(index, key, record); indexKey != nil {
+			if e := transaction.Bucket(index).Put(indexKey, key); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (this *boltWriter) update(transaction *bolt.Tx, records []Record) error {
+	recordsBucket := transaction.Bucket(BOLTRECORDS)
+	indexes := [][]byte{BOLTNAMES, BOLTRNAMES, BOLTDATA, BOLTADDRESSES}
+
+	// the keys of the new records per index, written in order like the records
+	type entry struct{ key, value []byte }
+	entries := make([][]entry, len(indexes))
+
+	// aggregated records are sorted by name, which keeps the writes to the records bucket local
+	for _, record := range records {
+		key := boltKey(record.Name, record.Class, record.Type, record.Data)
+		if value := recordsBucket.Get(key); value != nil {
+			if existing, ok := boltRecord(key, value); ok {
+				record.Count += existing.Count
+				if existing.FirstSeen.Before(record.FirstSeen) {
+					record.FirstSeen = existing.FirstSeen
+				}
+				if record.LastSeen.Before(existing.LastSeen) {
+					record.LastSeen = existing.LastSeen
+				}
+			}
+		} else {
+			// new record, index it
+			for i, index := range indexes {
+				if indexKey := boltIndexKey(index, key, record); indexKey != nil {
+					entries[i] = append(entries[i], entry{indexKey, key})
+				}
+			}
+		}
+
+		if e := recordsBucket.Put(key, boltValue(record)); e != nil {
+			return e
+		}
+	}
+
+	for i, index := range indexes {
+		sort.Slice(entries[i], func(j, k int) bool { return bytes.Compare(entries[i][j].key, entries[i][k].key) < 0 })
+		bucket := transaction.Bucket(index)
+		for _, entry := range entries[i] {
+			if e := bucket.Put(entry.key, entry.value); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (this *boltWriter) close() {
+	this.database.Close()
+}
+
+func boltOpen(database string, options *bolt.Options) (*bolt.DB, error) {
+	db, e := bolt.Open(database, 0644, options)
+	if e != nil {
+		return nil, fmt.Errorf("failed to open bbolt database \"%v\": %v", database, e)
+	}
+	return db, nil
+}
+
+// NewBoltWriter creates the writer shared by the bbolt message handlers of all
+// workers, and a store querying the database of the writer. The database file
+// is locked while written, other processes cannot open it (see OpenBoltStore).
+func NewBoltWriter(database string, size int, age time.Duration) (*answerWriter, Store) {
+	fmt.Fprintf(os.Stderr, "Creating bbolt writer \"%v\"\n", database)
+
+	// the free list is rebuilt when opened instead of written with every transaction
+	db, e := boltOpen(database, &bolt.Options{Timeout: 10 * time.Second, NoFreelistSync: true, FreelistType: bolt.FreelistMapType})
+	if e != nil {
+		fatalln(e)
+	}
+
+	e = db.Update(func(transaction *bolt.Tx) error {
+		// databases created before the addresses or the lower cased names were
+		// indexed, the reversed names were not lower cased either
+		missing := [][]byte{}
+		if transaction.Bucket(BOLTRECORDS) != nil {
+			if transaction.Bucket(BOLTADDRESSES) == nil {
+				missing = append(missing, BOLTADDRESSES)
+			}
+			if transaction.Bucket(BOLTNAMES) == nil {
+				if e := transaction.DeleteBucket(BOLTRNAMES); e != nil && e != bolt.ErrBucketNotFound {
+					return e
+				}
+				missing = append(missing, BOLTNAMES, BOLTRNAMES)
+			}
+		}
+
+		for _, bucket := range [][]byte{BOLTRECORDS, BOLTNAMES, BOLTRNAMES, BOLTDATA, BOLTADDRESSES} {
+			if _, e := transaction.CreateBucketIfNotExists(bucket); e != nil {
+				return e
+			}
+		}
+
+		if 0 < len(missing) {
+			fmt.Fprintf(os.Stderr, "Indexing %s of bbolt database \"%v\"\n", bytes.Join(missing, []byte(", ")), database)
+			return transaction.Bucket(BOLTRECORDS).ForEach(func(key []byte, value []byte) error {
+				if record, ok := boltRecord(key, value); ok {
+					return boltIndex(transaction, missing, key, record)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if e != nil {
+		fatalln(e)
+	}
+
+	return newAnswerWriter("bbolt", &boltWriter{database: db}, STORETYPES, size, age), &boltStore{database: db, shared: true}
+}
+
+// boltStore queries a bbolt database. Records are aggregates, a record
+// matches a time range when it was seen (between first and last seen) within
+// it, the count is the total count. A shared database is the database of the
+// writer, which closes it.
+type boltStore struct {
+	database *bolt.DB
+	shared   bool
+}
+
+func (this *boltStore) Close() error {
+	if this.shared {
+		return nil
+	}
+	return this.database.Close()
+}
+
+func (this *boltStore) matches(record Record, query Query) bool {
+	if !query.From.IsZero() && record.LastSeen.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && query.To.Before(record.FirstSeen) {
+		return false
+	}
+	if 0 < len(query.Types) {
+		for _, rrtype := range query.Types {
+			if strings.EqualFold(rrtype, record.Type) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// the records with keys (or, for an index, record keys) starting with the prefix
+func (this *boltStore) scan(bucket []byte, prefix []byte, query Query) ([]Record, error) {
+	records := []Record{}
+	e := this.database.View(func(transaction *bolt.Tx) error {
+		recordsBucket := transaction.Bucket(BOLTRECORDS)
+		if recordsBucket == nil {
+			return nil
+		}
+
+		cursor := transaction.Bucket(bucket).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			if !bytes.Equal(bucket, BOLTRECORDS) {
+				key, value = value, recordsBucket.Get(value)
+			}
+			if record, ok := boltRecord(key, value); ok && this.matches(record, query) {
+				records = append(records, record)
+			}
+		}
+		return nil
+	})
+	return merge(records), e
+}
+
+func (this *boltStore) Name(name string, query Query) ([]Record, error) {
+	kind, name, e := namePattern(name)
+	if e != nil {
+		return nil, e
+	}
+
+	// databases not written to since the lower cased names were indexed are case sensitive
+	names := BOLTNAMES
+	this.database.View(func(transaction *bolt.Tx) error {
+		if transaction.Bucket(BOLTNAMES) == nil {
+			names = BOLTRECORDS
+		}
+		return nil
+	})
+
+	switch {
+	case kind == NAMEBELOW && name == "":
+		return this.scan(BOLTRECORDS, nil, query)
+	case kind == NAMEBELOW:
+		prefix, _ := nameRange(reverse(name))
+		return this.scan(BOLTRNAMES, []byte(prefix), query)
+	case kind == NAMEPREFIX:
+		prefix, _ := nameRange(name)
+		return this.scan(names, []byte(prefix), query)
+	default:
+		return this.scan(names, boltKey(name, ""), query)
+	}
+}
+
+func (this *boltStore) Data(data string, query Query) ([]Record, error) {
+	return this.scan(BOLTDATA, boltKey(strings.TrimRight(data, "."), ""), query)
+}
+
+func (this *boltStore) Suffix(suffix string, query Query) ([]Record, error) {
+	rname := reverse(suffix)
+	if rname == "" {
+		// the root, all names
+		return this.scan(BOLTRECORDS, nil, query)
+	}
+
+	// the name itself and the names below it
+	records, e := this.scan(BOLTRNAMES, boltKey(rname, ""), query)
+	if e != nil {
+		return nil, e
+	}
+	prefix, _ := nameRange(rname)
+	below, e := this.scan(BOLTRNAMES, []byte(prefix), query)
+	if e != nil {
+		return nil, e
+	}
+	return merge(append(records, below...)), nil
+}
+
+func (this *boltStore) Network(network *net.IPNet, query Query) ([]Record, error) {
+	first, last := networkRange(network)
+
+	records := []Record{}
+	e := this.database.View(func(transaction *bolt.Tx) error {
+		recordsBucket, addressesBucket := transaction.Bucket(BOLTRECORDS), transaction.Bucket(BOLTADDRESSES)
+		if recordsBucket == nil {
+			return nil
+		}
+		if addressesBucket == nil {
+			// not indexed yet (until written to again), scan all records
+			return recordsBucket.ForEach(func(key []byte, value []byte) error {
+				if record, ok := boltRecord(key, value); ok && this.matches(record, query) {
+					if address := addressKey(record.Type, record.Data); address != nil && 0 <= bytes.Compare(address, first) && bytes.Compare(address, last) <= 0 {
+						records = append(records, record)
+					}
+				}
+				return nil
+			})
+		}
+
+		cursor := addressesBucket.Cursor()
+		for key, value := cursor.Seek(first); key != nil && bytes.Compare(key[:net.IPv6len], last) <= 0; key, value = cursor.Next() {
+			if record, ok := boltRecord(value, recordsBucket.Get(value)); ok && this.matches(record, query) {
+				records = append(records, record)
+			}
+		}
+		return nil
+	})
+	return merge(records), e
+}
+
+// the records are aggregated, the name resolves over all CNAME records observed
+// rather than the chains of the responses (see resolveRecords)
+func (this *boltStore) Resolve(name string, query Query) ([]Resolution, error) {
+	return resolveRecords(this, name, query)
+}
+
+// OpenBoltStore opens the bbolt database for queries. A database written by
+// passivedns -bolt is locked, it is queried over HTTP (/query) instead.
+func OpenBoltStore(database string) (Store, error) {
+	if _, e := os.Stat(database); e != nil {
+		return nil, e
+	}
+	db, e := bolt.Open(database, 0644, &bolt.Options{ReadOnly: true, Timeout: BOLTLOCKTIMEOUT})
+	if e == bolt.ErrTimeout {
+		return nil, fmt.Errorf("bbolt database \"%v\" is locked by its writer (passivedns -bolt), query it over HTTP (-http, /query) while written", database)
+	}
+	if e != nil {
+		return nil, fmt.Errorf("failed to open bbolt database \"%v\": %v", database, e)
+	}
+	return &boltStore{database: db}, nil
+}
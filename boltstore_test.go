@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// a bbolt writer on a database of its own and the store of the writer, the
+// writer is closed at the end of the test
+func boltTest(t *testing.T) (string, *boltWriter, Store) {
+	database := filepath.Join(t.TempDir(), "pdns.bolt")
+	writer, store := NewBoltWriter(database, 1000, time.Minute)
+	writer.acquire()
+	t.Cleanup(writer.release)
+	return database, writer.backend.(*boltWriter), store
+}
+
+func boltAnswer(seen time.Time, name string, rrtype uint16, data string) Answer {
+	return Answer{Time: seen, Name: name, Class: dns.ClassINET, Type: rrtype, Data: data}
+}
+
+func boltRecords(records []Record) []string {
+	found := []string{}
+	for _, record := range records {
+		found = append(found, fmt.Sprintf("%v %v %v %v", record.Name, record.Type, record.Data, record.Count))
+	}
+	return found
+}
+
+func TestBoltStore(t *testing.T) {
+	_, writer, store := boltTest(t)
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	batches := [][]Answer{
+		{boltAnswer(seen.Add(time.Hour), "www.example.com.", dns.TypeA, "192.0.2.1"),
+			boltAnswer(seen.Add(time.Hour), "www.example.com.", dns.TypeAAAA, "2001:db8::1"),
+			boltAnswer(seen, "web.example.com.", dns.TypeCNAME, "www.example.com."),
+			boltAnswer(seen, "mail.example.org.", dns.TypeA, "198.51.100.1"),
+			boltAnswer(seen, "alias.example.com.", dns.TypeCNAME, "*.cdn.example.net."),
+			boltAnswer(seen, "*.cdn.example.net.", dns.TypeA, "203.0.113.1"),
+			boltAnswer(seen, "edge.cdn.example.net.", dns.TypeA, "203.0.113.2")},
+		// merged into the records of the first batch, case variants (0x20) included
+		{boltAnswer(seen, "WwW.Example.COM.", dns.TypeA, "192.0.2.1"),
+			boltAnswer(seen.Add(2*time.Hour), "www.example.com.", dns.TypeA, "192.0.2.1"),
+			boltAnswer(seen, "Web.example.com.", dns.TypeCNAME, "WWW.example.com.")},
+	}
+	for _, batch := range batches {
+		if e := writer.insert(batch); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	www, e := store.Name("www.example.com", Query{Types: []string{"A"}})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(www) != 1 || www[0].Count != 3 || !www[0].FirstSeen.Equal(seen) || !www[0].LastSeen.Equal(seen.Add(2*time.Hour)) {
+		t.Errorf("got %v, expected the A record of www.example.com seen 3 times from %v to %v", www, seen, seen.Add(2*time.Hour))
+	}
+
+	network := func(cidr string) *net.IPNet {
+		_, network, _ := net.ParseCIDR(cidr)
+		return network
+	}
+	tests := []struct {
+		lookup   string
+		value    string
+		query    Query
+		expected []string
+	}{
+		{"name", "WWW.example.com.", Query{}, []string{"www.example.com A 192.0.2.1 3", "www.example.com AAAA 2001:db8::1 1"}},
+		{"name", "*.example.com", Query{}, []string{"alias.example.com CNAME *.cdn.example.net 1", "web.example.com CNAME www.example.com 2", "www.example.com A 192.0.2.1 3", "www.example.com AAAA 2001:db8::1 1"}},
+		{"name", "*.example.com", Query{Types: []string{"cname"}}, []string{"alias.example.com CNAME *.cdn.example.net 1", "web.example.com CNAME www.example.com 2"}},
+		{"name", "www.example.*", Query{}, []string{"www.example.com A 192.0.2.1 3", "www.example.com AAAA 2001:db8::1 1"}},
+		// the wildcard record, not the names below cdn.example.net
+		{"name", `\*.cdn.example.net`, Query{}, []string{"*.cdn.example.net A 203.0.113.1 1"}},
+		{"name", "*.cdn.example.net", Query{}, []string{"*.cdn.example.net A 203.0.113.1 1", "edge.cdn.example.net A 203.0.113.2 1"}},
+		// seen within the time range
+		{"name", "*.example.com", Query{From: seen.Add(90 * time.Minute)}, []string{"www.example.com A 192.0.2.1 3"}},
+		{"name", "*.example.com", Query{To: seen.Add(-time.Minute)}, []string{}},
+		{"data", "www.example.com", Query{}, []string{"web.example.com CNAME www.example.com 2"}},
+		{"data", "192.0.2.1", Query{}, []string{"www.example.com A 192.0.2.1 3"}},
+		{"suffix", "example.com", Query{}, []string{"alias.example.com CNAME *.cdn.example.net 1", "web.example.com CNAME www.example.com 2", "www.example.com A 192.0.2.1 3", "www.example.com AAAA 2001:db8::1 1"}},
+		{"suffix", "Mail.Example.org", Query{}, []string{"mail.example.org A 198.51.100.1 1"}},
+		{"network", "192.0.2.0/24", Query{}, []string{"www.example.com A 192.0.2.1 3"}},
+		{"network", "203.0.113.0/31", Query{}, []string{"*.cdn.example.net A 203.0.113.1 1"}},
+		{"network", "0.0.0.0/0", Query{}, []string{"*.cdn.example.net A 203.0.113.1 1", "edge.cdn.example.net A 203.0.113.2 1", "mail.example.org A 198.51.100.1 1", "www.example.com A 192.0.2.1 3"}},
+		{"network", "2001:db8::/32", Query{}, []string{"www.example.com AAAA 2001:db8::1 1"}},
+	}
+	for _, test := range tests {
+		var records []Record
+		var e error
+		switch test.lookup {
+		case "name":
+			records, e = store.Name(test.value, test.query)
+		case "data":
+			records, e = store.Data(test.value, test.query)
+		case "suffix":
+			records, e = store.Suffix(test.value, test.query)
+		case "network":
+			records, e = store.Network(network(test.value), test.query)
+		}
+		if e != nil {
+			t.Errorf("%v %v: %v", test.lookup, test.value, e)
+			continue
+		}
+		if found := boltRecords(records); fmt.Sprint(found) != fmt.Sprint(test.expected) {
+			t.Errorf("%v %v: got %q, expected %q", test.lookup, test.value, found, test.expected)
+		}
+	}
+}
+
+// the database is locked by the writer, other processes query it over HTTP
+func TestBoltLocked(t *testing.T) {
+	database, writer, store := boltTest(t)
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	if e := writer.insert([]Answer{boltAnswer(seen, "www.example.com.", dns.TypeA, "192.0.2.1")}); e != nil {
+		t.Fatal(e)
+	}
+
+	started := time.Now()
+	if _, e := OpenBoltStore(database); e == nil || !strings.Contains(e.Error(), "/query") {
+		t.Errorf("got %v, expected the database to be locked", e)
+	}
+	if waited := time.Since(started); 2*BOLTLOCKTIMEOUT < waited {
+		t.Errorf("waited %v for the lock", waited)
+	}
+
+	output := NewHttpOutput("127.0.0.1:0", 16, store)
+	output.acquire()
+	defer output.release()
+
+	recorder := httptest.NewRecorder()
+	output.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/query?lookup=name&value=WWW.example.com&type=a", nil))
+	var records []struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		Data  string `json:"data"`
+		Count int64  `json:"count"`
+	}
+	if e := json.Unmarshal(recorder.Body.Bytes(), &records); e != nil {
+		t.Fatalf("got %v %q: %v", recorder.Code, recorder.Body, e)
+	}
+	if len(records) != 1 || records[0].Name != "www.example.com" || records[0].Data != "192.0.2.1" || records[0].Count != 1 {
+		t.Errorf("got %v", records)
+	}
+
+	recorder = httptest.NewRecorder()
+	output.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/query?lookup=ip&value=192.0.2.0/33", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got %v for an invalid network, expected %v", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+// sustained inserts of new records by the shared bbolt writer fed by several
+// workers, the time includes the final flush
+func BenchmarkBoltWriter(b *testing.B) {
+	messages := benchmarkMessages(b, b.N)
+	writer, _ := NewBoltWriter(filepath.Join(b.TempDir(), "pdns.bolt"), 10000, 5*time.Second)
+	benchmarkWriter(b, writer, messages)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	dnstapserver "passivedns/dnstapserver"
+	"sync/atomic"
+	"time"
+
+	framestream "github.com/farsightsec/golang-framestream"
+)
+
+// frames buffered for the capture writer, e.g. while a file is rotated
+const CAPTUREBUFFER = 65536
+
+// captureFrameHandler writes the raw Dnstap frames to a Frame Streams file
+// (as written by "dnstap -w"). The file is rotated when it exceeds a size
+// and/or an age, rotated files are optionally compressed (gzip) and only the
+// most recent ones are retained (see rotatingFile). The frames are written by
+// a goroutine of its own, frames that do not fit in its buffer are dropped
+// (and counted) rather than stalling the input.
+type captureFrameHandler struct {
+	file   *rotatingFile
+	writer *framestream.Writer
+
+	frames  chan []byte
+	done    chan struct{}
+	dropped uint64
+}
+
+func (this *captureFrameHandler) open() error {
+	if e := this.file.open(); e != nil {
+		return e
+	}
+
+	writer, e := framestream.NewWriter(this.file, &framestream.WriterOptions{ContentTypes: [][]byte{[]byte(dnstapserver.CONTENT_TYPE_PROTOBUF_DNSTAP)}})
+	if e != nil {
+		this.file.rotate()
+		return e
+	}
+	this.writer = writer
+	return nil
+}
+
+// finish the current file and move it aside
+func (this *captureFrameHandler) rotate() {
+	if this.writer != nil {
+		if e := this.writer.Close(); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to finish capture file \"%v\": %v\n", this.file.path, e)
+		}
+		this.writer = nil
+	}
+	this.file.rotate()
+}
+
+func (this *captureFrameHandler) write(frame []byte) {
+	if this.writer == nil {
+		if e := this.open(); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create capture file \"%v\": %v\n", this.file.path, e)
+			return
+		}
+	}
+
+	if _, e := this.writer.WriteFrame(frame); e != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write to capture file \"%v\": %v\n", this.file.path, e)
+		this.rotate()
+		return
+	}
+	if this.file.expired() {
+		this.rotate()
+	}
+}
+
+// write the frames, flush the file once a second and rotate it when it is
+// too old
+func (this *captureFrameHandler) run() {
+	defer close(this.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame, ok := <-this.frames:
+			if !ok {
+				this.rotate()
+				this.file.close()
+				return
+			}
+			this.write(frame)
+		case <-ticker.C:
+			if this.writer != nil {
+				if this.file.expired() {
+					this.rotate()
+				} else if e := this.writer.Flush(); e != nil {
+					fmt.Fprintf(os.Stderr, "Failed to flush capture file \"%v\": %v\n", this.file.path, e)
+				}
+			}
+		}
+	}
+}
+
+func (this *captureFrameHandler) HandleFrame(frame []byte) {
+	select {
+	case this.frames <- frame:
+	default:
+		atomic.AddUint64(&this.dropped, 1)
+	}
+}
+
+func (this *captureFrameHandler) Close() {
+	close(this.frames)
+	<-this.done
+	if dropped := atomic.LoadUint64(&this.dropped); 0 < dropped {
+		fmt.Fprintf(os.Stderr, "Capture file \"%v\" dropped %v frame(s)\n", this.file.path, dropped)
+	}
+}
+
+func NewCaptureFrameHandler(path string, size int64, interval time.Duration, compress bool, retain int) dnstapserver.DnstapFrameHandler {
+	fmt.Fprintf(os.Stderr, "Creating Dnstap capture frame handler \"%v\"\n", path)
+
+	file, e := newRotatingFile("capture", path, size, interval, compress, retain)
+	if e != nil {
+		fatalln(e)
+	}
+
+	capture := &captureFrameHandler{file: file, frames: make(chan []byte, CAPTUREBUFFER), done: make(chan struct{})}
+	go capture.run()
+
+	return capture
+}
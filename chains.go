@@ -0,0 +1,209 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// longest CNAME chain followed, guards against loops
+const MAXCHAIN = 16
+
+// Chain is a CNAME chain of a response, from the name (the name queried) over
+// the hops (the intermediate names) to the target (the last CNAME target).
+type Chain struct {
+	Time   time.Time
+	Id     uint16
+	Name   string
+	Target string
+	Hops   []string
+}
+
+// the answers are of the same response, they share the time, the message ID,
+// the question and the client
+func sameResponse(answer *Answer, other *Answer) bool {
+	return answer.Time.Equal(other.Time) && answer.Id == other.Id && strings.EqualFold(answer.QuestionName, other.QuestionName) && answer.QueryAddress.Equal(other.QueryAddress)
+}
+
+// the CNAME chains of the answers, the answers of a response are consecutive
+// (as passed to the writers)
+func cnameChains(answers []Answer) []Chain {
+	chains := []Chain{}
+	for start := 0; start < len(answers); {
+		end := start + 1
+		for end < len(answers) && sameResponse(&answers[end], &answers[start]) {
+			end++
+		}
+		chains = append(chains, responseChains(answers[start:end])...)
+		start = end
+	}
+	return chains
+}
+
+// the CNAME chains of the answers of a response, one from each owner of a
+// CNAME, the first is from the name queried and the others are its tails
+// (an intermediate name resolves over the rest of the chain)
+func responseChains(answers []Answer) []Chain {
+	targets := make(map[string]string)
+	owners := []string{}
+	for _, answer := range answers {
+		if answer.Type == dns.TypeCNAME {
+			owner := strings.ToLower(answer.Name)
+			if _, ok := targets[owner]; !ok {
+				targets[owner] = answer.Data
+				owners = append(owners, answer.Name)
+			}
+		}
+	}
+
+	chains := make([]Chain, 0, len(owners))
+	for _, owner := range owners {
+		chain := Chain{Time: answers[0].Time, Id: answers[0].Id, Name: strings.TrimRight(owner, "."), Hops: []string{}}
+		name := owner
+		// a loop ends before a name is repeated
+		visited := map[string]bool{strings.ToLower(owner): true}
+		for hops := 0; hops < MAXCHAIN; hops++ {
+			target, ok := targets[strings.ToLower(name)]
+			if !ok || visited[strings.ToLower(target)] {
+				break
+			}
+			visited[strings.ToLower(target)] = true
+			if chain.Target != "" {
+				chain.Hops = append(chain.Hops, chain.Target)
+			}
+			chain.Target, name = strings.TrimRight(target, "."), target
+		}
+		// not a CNAME of itself
+		if chain.Target != "" {
+			chains = append(chains, chain)
+		}
+	}
+	return chains
+}
+
+// Resolution is what a name resolved to, an address (A, AAAA) of the name or
+// of the target of its CNAME chain. A chain whose target had no addresses
+// resolves to no data.
+type Resolution struct {
+	Name      string
+	Hops      []string
+	Target    string
+	Type      string
+	Data      string
+	Count     int64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// the types a name resolves to, addresses unless the query has types
+func resolveTypes(query Query) []string {
+	if 0 < len(query.Types) {
+		return query.Types
+	}
+	return []string{"A", "AAAA"}
+}
+
+// resolve a name by following the CNAME records of a store (rather than the
+// chains of responses), all targets observed are followed
+func resolveRecords(store Store, name string, query Query) ([]Resolution, error) {
+	name = strings.TrimRight(name, ".")
+	resolutions := []Resolution{}
+	types := append(append([]string{}, resolveTypes(query)...), "CNAME")
+
+	// the records of the current name, reached over the hops and the CNAME record (nil for the name)
+	var follow func(current string, hops []string, cname *Record) error
+	follow = func(current string, hops []string, cname *Record) error {
+		query.Types = types
+		records, e := store.Name(current, query)
+		if e != nil {
+			return e
+		}
+
+		target := ""
+		if cname != nil {
+			target = current
+			if len(records) == 0 {
+				// a target without records
+				resolutions = append(resolutions, Resolution{Name: name, Hops: hops, Target: target, Count: cname.Count, FirstSeen: cname.FirstSeen, LastSeen: cname.LastSeen})
+			}
+		}
+
+		for _, record := range records {
+			if record.Type != "CNAME" {
+				resolutions = append(resolutions, Resolution{Name: name, Hops: hops, Target: target, Type: record.Type, Data: record.Data, Count: record.Count, FirstSeen: record.FirstSeen, LastSeen: record.LastSeen})
+				continue
+			}
+
+			// loops and overly long chains end here
+			visited := strings.EqualFold(record.Data, name) || strings.EqualFold(record.Data, current)
+			for _, hop := range hops {
+				visited = visited || strings.EqualFold(hop, record.Data)
+			}
+			if visited || MAXCHAIN <= len(hops) {
+				continue
+			}
+
+			next := append([]string{}, hops...)
+			if cname != nil {
+				next = append(next, current)
+			}
+			record := record
+			if e := follow(record.Data, next, &record); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	if e := follow(name, []string{}, nil); e != nil {
+		return nil, e
+	}
+	return mergeResolutions(resolutions), nil
+}
+
+// merge resolutions over the same chain to the same data (e.g. from several
+// partitions), the result is ordered by target, type and data
+func mergeResolutions(resolutions []Resolution) []Resolution {
+	type key struct{ name, hops, target, rrtype, data string }
+
+	merged := make(map[key]*Resolution, len(resolutions))
+	order := make([]key, 0, len(resolutions))
+	for _, resolution := range resolutions {
+		k := key{resolution.Name, strings.Join(resolution.Hops, " "), resolution.Target, resolution.Type, resolution.Data}
+		if existing, ok := merged[k]; ok {
+			existing.Count += resolution.Count
+			if resolution.FirstSeen.Before(existing.FirstSeen) {
+				existing.FirstSeen = resolution.FirstSeen
+			}
+			if existing.LastSeen.Before(resolution.LastSeen) {
+				existing.LastSeen = resolution.LastSeen
+			}
+		} else {
+			copy := resolution
+			merged[k] = &copy
+			order = append(order, k)
+		}
+	}
+
+	result := make([]Resolution, 0, len(order))
+	for _, k := range order {
+		result = append(result, *merged[k])
+	}
+	sortResolutions(result)
+	return result
+}
+
+// order by target, type and data
+func sortResolutions(resolutions []Resolution) {
+	sort.SliceStable(resolutions, func(i, j int) bool {
+		if resolutions[i].Target != resolutions[j].Target {
+			return resolutions[i].Target < resolutions[j].Target
+		}
+		if resolutions[i].Type != resolutions[j].Type {
+			return resolutions[i].Type < resolutions[j].Type
+		}
+		return resolutions[i].Data < resolutions[j].Data
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func chainAnswer(seen time.Time, id uint16, question string, name string, data string) Answer {
+	return Answer{Time: seen, Id: id, Name: name, Class: dns.ClassINET, Type: dns.TypeCNAME, Data: data, QuestionName: question, QueryAddress: net.ParseIP("198.51.100.1")}
+}
+
+func TestCnameChains(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		answers []Answer
+		chains  []string
+	}{
+		{"chain", []Answer{
+			chainAnswer(seen, 1, "www.example.com.", "www.example.com.", "web.example.net."),
+			chainAnswer(seen, 1, "www.example.com.", "web.example.net.", "edge.cdn.example.org."),
+			{Time: seen, Id: 1, Name: "edge.cdn.example.org.", Type: dns.TypeA, Data: "192.0.2.1", QuestionName: "www.example.com.", QueryAddress: net.ParseIP("198.51.100.1")}},
+			[]string{"www.example.com > web.example.net > edge.cdn.example.org", "web.example.net > edge.cdn.example.org"}},
+		// the answers of a response (the same time and ID) are one chain
+		{"case variants", []Answer{
+			chainAnswer(seen, 1, "WwW.example.com.", "WwW.example.com.", "Web.example.net."),
+			chainAnswer(seen, 1, "www.Example.com.", "web.Example.net.", "edge.example.org.")},
+			[]string{"WwW.example.com > Web.example.net > edge.example.org", "web.Example.net > edge.example.org"}},
+		// responses with the same time and ID, to other questions or clients
+		{"distinct responses", []Answer{
+			chainAnswer(seen, 1, "www.example.com.", "www.example.com.", "web.example.net."),
+			chainAnswer(seen, 1, "web.example.net.", "web.example.net.", "other.example.org."),
+			{Time: seen, Id: 1, Name: "web.example.net.", Type: dns.TypeCNAME, Data: "third.example.org.", QuestionName: "web.example.net.", QueryAddress: net.ParseIP("198.51.100.2")},
+			chainAnswer(seen.Add(time.Millisecond), 1, "web.example.net.", "web.example.net.", "fourth.example.org.")},
+			[]string{"www.example.com > web.example.net", "web.example.net > other.example.org", "web.example.net > third.example.org", "web.example.net > fourth.example.org"}},
+		{"loop", []Answer{
+			chainAnswer(seen, 1, "a.example.com.", "a.example.com.", "b.example.com."),
+			chainAnswer(seen, 1, "a.example.com.", "b.example.com.", "A.example.com.")},
+			[]string{"a.example.com > b.example.com", "b.example.com > A.example.com"}},
+		// a loop not including the name queried
+		{"tail loop", []Answer{
+			chainAnswer(seen, 1, "a.example.com.", "a.example.com.", "b.example.com."),
+			chainAnswer(seen, 1, "a.example.com.", "b.example.com.", "c.example.com."),
+			chainAnswer(seen, 1, "a.example.com.", "c.example.com.", "b.example.com.")},
+			[]string{"a.example.com > b.example.com > c.example.com", "b.example.com > c.example.com", "c.example.com > b.example.com"}},
+		{"self", []Answer{
+			chainAnswer(seen, 1, "a.example.com.", "a.example.com.", "a.example.com.")},
+			[]string{}},
+	}
+	for _, test := range tests {
+		found := []string{}
+		for _, chain := range cnameChains(test.answers) {
+			found = append(found, strings.Join(append(append([]string{chain.Name}, chain.Hops...), chain.Target), " > "))
+		}
+		if fmt.Sprint(found) != fmt.Sprint(test.chains) {
+			t.Errorf("%v: got %q, expected %q", test.name, found, test.chains)
+		}
+	}
+}
+
+// the CNAME records of a store are followed to the addresses, loops end
+func TestResolveRecords(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	record := func(name string, rrtype string, data string) Record {
+		return Record{Name: name, Class: "IN", Type: rrtype, Data: data, Count: 1, FirstSeen: seen, LastSeen: seen}
+	}
+	store := &memoryStore{records: []Record{
+		record("www.example.com", "CNAME", "web.example.net"),
+		record("web.example.net", "CNAME", "edge.example.org"),
+		record("edge.example.org", "A", "192.0.2.1"),
+		record("a.example.com", "CNAME", "b.example.com"),
+		record("b.example.com", "CNAME", "c.example.com"),
+		record("c.example.com", "CNAME", "b.example.com"),
+		record("c.example.com", "A", "192.0.2.3"),
+		record("self.example.com", "CNAME", "SELF.example.com"),
+		record("dangling.example.com", "CNAME", "gone.example.com"),
+	}}
+
+	tests := []struct {
+		name        string
+		resolutions []string
+	}{
+		{"www.example.com", []string{"www.example.com > web.example.net > edge.example.org A 192.0.2.1"}},
+		{"a.example.com", []string{"a.example.com > b.example.com > c.example.com A 192.0.2.3"}},
+		{"self.example.com", []string{}},
+		{"dangling.example.com", []string{"dangling.example.com > gone.example.com  "}},
+	}
+	for _, test := range tests {
+		resolutions, e := resolveRecords(store, test.name, Query{})
+		if e != nil {
+			t.Fatalf("%v: %v", test.name, e)
+		}
+		found := []string{}
+		for _, resolution := range resolutions {
+			found = append(found, fmt.Sprintf("%v %v %v", strings.Join(append(append([]string{resolution.Name}, resolution.Hops...), resolution.Target), " > "), resolution.Type, resolution.Data))
+		}
+		if fmt.Sprint(found) != fmt.Sprint(test.resolutions) {
+			t.Errorf("%v: got %q, expected %q", test.name, found, test.resolutions)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the columns of the CSV/TSV output
+var CSVCOLUMNS = map[string]func(answer *Answer) string{
+	"id":     func(answer *Answer) string { return strconv.Itoa(int(answer.Id)) },
+	"time":   func(answer *Answer) string { return answer.Time.Format(time.RFC3339Nano) },
+	"sensor": func(answer *Answer) string { return answer.Sensor },
+	"name":   func(answer *Answer) string { return strings.TrimRight(answer.Name, ".") },
+	"ttl":    func(answer *Answer) string { return strconv.FormatUint(uint64(answer.Ttl), 10) },
+	"class":  func(answer *Answer) string { return dns.ClassToString[answer.Class] },
+	"type":   func(answer *Answer) string { return dns.TypeToString[answer.Type] },
+	"data":   func(answer *Answer) string { return strings.TrimRight(answer.Data, ".") },
+	"query_address": func(answer *Answer) string {
+		if answer.QueryAddress == nil {
+			return ""
+		}
+		return answer.QueryAddress.String()
+	},
+	"response_address": func(answer *Answer) string {
+		if answer.ResponseAddress == nil {
+			return ""
+		}
+		return answer.ResponseAddress.String()
+	}}
+
+// parse a comma separated list of column names
+func csvColumns(value string) ([]string, error) {
+	columns := []string{}
+	for _, column := range strings.Split(value, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := CSVCOLUMNS[column]; !ok {
+			return nil, fmt.Errorf("invalid column \"%v\"", column)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// csvOutput writes a line per answer to stdout or to a file, shared by the
+// message handlers of all workers. Fields containing the delimiter, quotes or
+// line breaks (e.g. TXT data) are quoted. The file is rotated when it exceeds
+// a size and/or an age (see rotatingFile), the header is repeated in each
+// file.
+type csvOutput struct {
+	mutex     *sync.Mutex
+	delimiter rune
+	columns   []string
+	header    bool
+
+	// nil for stdout
+	file   *rotatingFile
+	writer *csv.Writer
+
+	references int
+}
+
+func (this *csvOutput) open() error {
+	var output io.Writer = os.Stdout
+	if this.file != nil {
+		if e := this.file.open(); e != nil {
+			return e
+		}
+		output = this.file
+	}
+
+	this.writer = csv.NewWriter(output)
+	this.writer.Comma = this.delimiter
+
+	if this.header {
+		return this.writer.Write(this.columns)
+	}
+	return nil
+}
+
+// the path of the file, "-" for stdout
+func (this *csvOutput) path() string {
+	if this.file == nil {
+		return "-"
+	}
+	return this.file.path
+}
+
+func (this *csvOutput) write(answers []Answer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.file != nil && this.file.expired() {
+		this.file.rotate()
+		this.writer = nil
+	}
+	if this.writer == nil {
+		if e := this.open(); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create CSV file \"%v\": %v\n", this.path(), e)
+			return
+		}
+	}
+
+	record := make([]string, len(this.columns))
+	for i := range answers {
+		for j, column := range this.columns {
+			record[j] = CSVCOLUMNS[column](&answers[i])
+		}
+		this.writer.Write(record)
+	}
+
+	// whole lines, as they arrive
+	this.writer.Flush()
+	if e := this.writer.Error(); e != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write CSV \"%v\": %v\n", this.path(), e)
+	}
+
+	if this.file != nil && this.file.expired() {
+		this.file.rotate()
+		this.writer = nil
+	}
+}
+
+func (this *csvOutput) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *csvOutput) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		if this.writer != nil {
+			this.writer.Flush()
+		}
+		if this.file != nil {
+			this.file.close()
+		}
+	}
+}
+
+// NewCsvOutput creates the output shared by the CSV message handlers of all
+// workers, path is a file or "-" for stdout (which is not rotated).
+func NewCsvOutput(path string, delimiter rune, columns []string, header bool, size int64, interval time.Duration) *csvOutput {
+	fmt.Fprintf(os.Stderr, "Creating CSV output \"%v\"\n", path)
+
+	output := &csvOutput{mutex: new(sync.Mutex), delimiter: delimiter, columns: columns, header: header}
+	if path != "-" {
+		file, e := newRotatingFile("CSV", path, size, interval, false, 0)
+		if e != nil {
+			fatalln(e)
+		}
+		output.file = file
+	}
+
+	return output
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fields with the delimiter, quotes or line breaks are quoted, quotes doubled
+func TestCsvOutput(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	answers := []Answer{
+		{Time: seen, Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Data: "192.0.2.1"},
+		{Time: seen, Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeTXT, Data: `"v=spf1 a, mx" "-all"`},
+		{Time: seen, Name: "text.example.com.", Class: dns.ClassINET, Type: dns.TypeTXT, Data: "first\nsecond\tthird"},
+		{Time: seen, Name: "name,with\"quote.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Data: "192.0.2.2"},
+	}
+
+	tests := []struct {
+		delimiter rune
+		expected  string
+	}{
+		{',', "name,type,data\n" +
+			"www.example.com,A,192.0.2.1\n" +
+			"www.example.com,TXT,\"\"\"v=spf1 a, mx\"\" \"\"-all\"\"\"\n" +
+			"text.example.com,TXT,\"first\nsecond\tthird\"\n" +
+			"\"name,with\"\"quote.example.com\",A,192.0.2.2\n"},
+		{'\t', "name\ttype\tdata\n" +
+			"www.example.com\tA\t192.0.2.1\n" +
+			"www.example.com\tTXT\t\"\"\"v=spf1 a, mx\"\" \"\"-all\"\"\"\n" +
+			"text.example.com\tTXT\t\"first\nsecond\tthird\"\n" +
+			"\"name,with\"\"quote.example.com\"\tA\t192.0.2.2\n"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "pdns.csv")
+		output := NewCsvOutput(path, test.delimiter, []string{"name", "type", "data"}, true, 0, 0)
+		output.acquire()
+		output.write(answers)
+		output.release()
+
+		written, e := os.ReadFile(path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if string(written) != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.delimiter, written, test.expected)
+		}
+	}
+}
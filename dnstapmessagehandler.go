@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	dnstap "passivedns/dnstap"
 	dnstapserver "passivedns/dnstapserver"
+	pdns "passivedns/pdns"
 	"strconv"
 	"strings"
 	"time"
@@ -40,33 +42,82 @@ type Answer struct {
 	Class uint16
 	Type  uint16
 	Data  string
+
+	// the question of the response, e.g. the name queried of a CNAME-expanded
+	// A record or the ANY of an answer to an ANY query (empty if none)
+	QuestionName  string
+	QuestionType  uint16
+	QuestionClass uint16
+
+	// identity of the sensor (DNS server) if known, and the addresses of the
+	// querier and the responder (e.g. the resolver and the authoritative server)
+	Sensor          string
+	QueryAddress    net.IP
+	ResponseAddress net.IP
 }
 
 func (this *Answer) Json() (string, bool) {
-	if bytes, e := json.Marshal(
-		struct {
-			Id    uint16        `json:"id"`
-			Time  time.Time     `json:"time"`
-			Name  string        `json:"name"`
-			Ttl   uint32        `json:"ttl"`
-			Class []interface{} `json:"class"`
-			Type  []interface{} `json:"type"`
-			Data  string        `json:"data"`
-		}{
-			Id:    this.Id,
-			Time:  this.Time,
-			Name:  strings.TrimRight(this.Name, "."),
-			Ttl:   this.Ttl,
-			Class: []interface{}{this.Class, dns.ClassToString[this.Class]},
-			Type:  []interface{}{this.Type, dns.TypeToString[this.Type]},
-			Data:  strings.TrimRight(this.Data, "."),
-		}); e == nil {
+	answer := struct {
+		Id              uint16        `json:"id"`
+		Time            time.Time     `json:"time"`
+		Sensor          string        `json:"sensor,omitempty"`
+		Name            string        `json:"name"`
+		Ttl             uint32        `json:"ttl"`
+		Class           []interface{} `json:"class"`
+		Type            []interface{} `json:"type"`
+		Data            string        `json:"data"`
+		QuestionName    string        `json:"qname,omitempty"`
+		QuestionType    []interface{} `json:"qtype,omitempty"`
+		QuestionClass   []interface{} `json:"qclass,omitempty"`
+		QueryAddress    net.IP        `json:"query_address,omitempty"`
+		ResponseAddress net.IP        `json:"response_address,omitempty"`
+	}{
+		Id:              this.Id,
+		Time:            this.Time,
+		Sensor:          this.Sensor,
+		Name:            strings.TrimRight(this.Name, "."),
+		Ttl:             this.Ttl,
+		Class:           []interface{}{this.Class, dns.ClassToString[this.Class]},
+		Type:            []interface{}{this.Type, dns.TypeToString[this.Type]},
+		Data:            strings.TrimRight(this.Data, "."),
+		QuestionName:    strings.TrimRight(this.QuestionName, "."),
+		QueryAddress:    this.QueryAddress,
+		ResponseAddress: this.ResponseAddress,
+	}
+	if this.QuestionName != "" {
+		answer.QuestionType = []interface{}{this.QuestionType, dns.TypeToString[this.QuestionType]}
+		answer.QuestionClass = []interface{}{this.QuestionClass, dns.ClassToString[this.QuestionClass]}
+	}
+
+	if bytes, e := json.Marshal(answer); e == nil {
 		return string(bytes), true
 	} else {
 		return "", false
 	}
 }
 
+// see pdns/pdns.proto
+func (this *Answer) Protobuf() *pdns.Answer {
+	answer := &pdns.Answer{
+		TimeSec:         uint64(this.Time.Unix()),
+		TimeNsec:        uint32(this.Time.Nanosecond()),
+		Sensor:          this.Sensor,
+		Id:              uint32(this.Id),
+		Name:            strings.TrimRight(this.Name, "."),
+		Ttl:             this.Ttl,
+		Class:           dns.ClassToString[this.Class],
+		Type:            dns.TypeToString[this.Type],
+		Data:            strings.TrimRight(this.Data, "."),
+		QueryAddress:    this.QueryAddress,
+		ResponseAddress: this.ResponseAddress}
+	if this.QuestionName != "" {
+		answer.QuestionName = strings.TrimRight(this.QuestionName, ".")
+		answer.QuestionType = dns.TypeToString[this.QuestionType]
+		answer.QuestionClass = dns.ClassToString[this.QuestionClass]
+	}
+	return answer
+}
+
 // see github.com/miekg/dns/types.go
 func data(rr dns.RR) (string, bool) {
 	switch rrtype := rr.(type) {
@@ -154,6 +205,13 @@ func answers(message *dnstap.Message, types []uint16) ([]Answer, error) {
 						answer.Ttl = rr.Header().Ttl
 						answer.Class = rr.Header().Class
 						answer.Type = rr.Header().Rrtype
+						if 0 < len(msg.Question) {
+							answer.QuestionName = msg.Question[0].Name
+							answer.QuestionType = msg.Question[0].Qtype
+							answer.QuestionClass = msg.Question[0].Qclass
+						}
+						answer.QueryAddress = message.QueryAddress
+						answer.ResponseAddress = message.ResponseAddress
 						if data, ok := data(rr); ok {
 							answer.Data = data
 						} else {
@@ -201,18 +259,171 @@ func NewResolverResponseJsonMessageHandler(output io.Writer) dnstapserver.Dnstap
 	return &resolverResponseJsonMessageHandler{output: output}
 }
 
-type resolverResponseSqliteMessageHandler struct {
-	db      *sql.DB
-	size    int
-	answers []Answer
+// answerOutput receives the answers of resolver responses, e.g. the CSV
+// output or the SQLite3 writer. An output is shared by the message handlers of
+// all workers and closed when the last one is released.
+type answerOutput interface {
+	write(answers []Answer)
+	acquire()
+	release()
+}
+
+type resolverResponseOutputMessageHandler struct {
+	outputs []answerOutput
+}
+
+// the message is unpacked once, all outputs get the same answers and must
+// not modify them
+func (this *resolverResponseOutputMessageHandler) handle(message *dnstap.Message, sensor string) {
+	if resolverResponseMessage(message) {
+		if answers, e := answers(message, []uint16{}); e == nil && 0 < len(answers) {
+			for i := range answers {
+				answers[i].Sensor = sensor
+			}
+			for _, output := range this.outputs {
+				output.write(answers)
+			}
+		}
+	}
+}
+
+func (this *resolverResponseOutputMessageHandler) Handle(message *dnstap.Message) {
+	this.handle(message, "")
+}
+
+func (this *resolverResponseOutputMessageHandler) HandleEnvelope(envelope *dnstap.Dnstap) {
+	this.handle(envelope.Message, string(envelope.Identity))
+}
+
+func (this *resolverResponseOutputMessageHandler) Close() {
+	for _, output := range this.outputs {
+		output.release()
+	}
+}
+
+// NewResolverResponseOutputMessageHandler passes the answers of resolver
+// responses to (shared) outputs, e.g. the CSV output and the SQLite3 writer.
+func NewResolverResponseOutputMessageHandler(outputs ...answerOutput) dnstapserver.DnstapMessageHandler {
+	for _, output := range outputs {
+		output.acquire()
+	}
+	return &resolverResponseOutputMessageHandler{outputs: outputs}
+}
+
+// layout of the time column, sorts chronologically (as text) in the local time zone
+const SQLITETIMEFORMAT = "2006-01-02 15:04:05.999999"
+
+// the SQLite driver with the functions of the passive DNS schema,
+// pdns_reverse(name) returns the reversed, lower cased name (see reverse) and
+// pdns_address(type, data) the address of A and AAAA data (see addressKey)
+const SQLITEDRIVER = "sqlite3_pdns"
+
+func init() {
+	sql.Register(SQLITEDRIVER, &sqlite3.SQLiteDriver{
+		ConnectHook: func(connection *sqlite3.SQLiteConn) error {
+			if e := connection.RegisterFunc("pdns_reverse", reverse, true); e != nil {
+				return e
+			}
+			return connection.RegisterFunc("pdns_address", addressKey, true)
+		}})
+}
+
+// columns added to the answers table later, with the values of the rows
+// written before
+var SQLITEADDED = []struct {
+	name       string
+	definition string
+	value      string
+}{
+	{"rname", "TEXT NOT NULL DEFAULT ''", "pdns_reverse(name)"},
+	{"address", "BLOB", "pdns_address(type, data)"},
+	// the question of the response, unknown for the rows written before
+	{"qname", "TEXT", "NULL"},
+	{"qtype", "TEXT", "NULL"},
+	{"qclass", "TEXT", "NULL"}}
+
+// append connection parameters to a SQLite database file name
+func dsn(database string, parameters string) string {
+	if strings.Contains(database, "?") {
+		return database + "&" + parameters
+	}
+	return database + "?" + parameters
+}
+
+// sqliteWriter writes the answers to a SQLite database, or to one database
+// per day or week (partition).
+type sqliteWriter struct {
+	database  string
+	partition string
+	dbs       map[string]*sql.DB
+}
+
+// the database (partition) for answers seen at the given time
+func (this *sqliteWriter) db(seen time.Time) *sql.DB {
+	file := partitionFile(this.database, this.partition, seen)
+	if db, ok := this.dbs[file]; ok {
+		return db
+	}
+
+	if this.partition != PARTITIONNONE {
+		fmt.Fprintf(os.Stderr, "Rolling over to SQLite3 partition \"%v\"\n", file)
+	}
+	db := sqliteCreate(file)
+	this.dbs[file] = db
+
+	// keep the two most recent partitions open, answers seen just before the boundary are still arriving
+	for 2 < len(this.dbs) {
+		oldest := file
+		for partition := range this.dbs {
+			if partition < oldest {
+				oldest = partition
+			}
+		}
+		this.dbs[oldest].Close()
+		delete(this.dbs, oldest)
+	}
+
+	return db
 }
 
-func (this *resolverResponseSqliteMessageHandler) insert(answers []Answer) error {
+func (this *sqliteWriter) insert(answers []Answer) error {
+	// group the answers by partition
+	partitions := []string{}
+	grouped := make(map[string][]Answer)
+	for _, answer := range answers {
+		file := partitionFile(this.database, this.partition, answer.Time)
+		if _, ok := grouped[file]; !ok {
+			partitions = append(partitions, file)
+		}
+		grouped[file] = append(grouped[file], answer)
+	}
+
+	for _, file := range partitions {
+		if e := sqliteInsert(this.db(grouped[file][0].Time), grouped[file]); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (this *sqliteWriter) close() {
+	for _, db := range this.dbs {
+		db.Close()
+	}
+}
+
+func sqliteInsert(db *sql.DB, answers []Answer) error {
 	if 0 < len(answers) {
-		if insert, e := this.db.Prepare("INSERT INTO answers (time, id, name, ttl , class, type, data) VALUES(?, ?, ?, ?, ?, ?, ?)"); e == nil {
-			if transaction, e := this.db.Begin(); e == nil {
+		if transaction, e := db.Begin(); e == nil {
+			if insert, e := transaction.Prepare("INSERT INTO answers (time, id, name, rname, ttl , class, type, data, address, qname, qtype, qclass) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"); e == nil {
+				defer insert.Close()
 				for _, answer := range answers {
-					_, e := transaction.Stmt(insert).Exec(answer.Time.Format("2006-01-02 15:04:05.999999"), answer.Id, strings.TrimRight(answer.Name, "."), answer.Ttl, dns.ClassToString[answer.Class], dns.TypeToString[answer.Type], strings.TrimRight(answer.Data, "."))
+					// responses without a question leave it NULL
+					var qname, qtype, qclass interface{}
+					if answer.QuestionName != "" {
+						qname, qtype, qclass = strings.TrimRight(answer.QuestionName, "."), dns.TypeToString[answer.QuestionType], dns.ClassToString[answer.QuestionClass]
+					}
+					_, e := insert.Exec(answer.Time.Format(SQLITETIMEFORMAT), answer.Id, strings.TrimRight(answer.Name, "."), reverse(answer.Name), answer.Ttl, dns.ClassToString[answer.Class], dns.TypeToString[answer.Type], strings.TrimRight(answer.Data, "."), addressKey(dns.TypeToString[answer.Type], answer.Data), qname, qtype, qclass)
 					if e != nil {
 						if sqle, ok := e.(sqlite3.Error); ok {
 							if sqle.ExtendedCode != sqlite3.ErrConstraintPrimaryKey && sqle.ExtendedCode != sqlite3.ErrConstraintUnique {
@@ -225,76 +436,95 @@ func (this *resolverResponseSqliteMessageHandler) insert(answers []Answer) error
 						}
 					}
 				}
-				transaction.Commit()
+				if e := sqliteInsertChains(transaction, cnameChains(answers)); e != nil {
+					transaction.Rollback()
+					return e
+				}
+				return transaction.Commit()
 			} else {
-				fatalln(e)
+				transaction.Rollback()
+				return e
 			}
 		} else {
-			fatalln(e)
+			return e
 		}
 	}
 	return nil
 }
 
-func (this *resolverResponseSqliteMessageHandler) Handle(message *dnstap.Message) {
-	if resolverResponseMessage(message) {
-		// add the answsers to the cache
-		if answers, e := answers(message, []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME}); e == nil && 0 < len(answers) {
-			this.answers = append(this.answers, answers...)
+// insert the CNAME chains, chains of responses seen before are ignored
+func sqliteInsertChains(transaction *sql.Tx, chains []Chain) error {
+	if 0 < len(chains) {
+		insert, e := transaction.Prepare("INSERT OR IGNORE INTO chains (time, id, name, target, hops) VALUES(?, ?, ?, ?, ?)")
+		if e != nil {
+			return e
 		}
+		defer insert.Close()
 
-		// insert cached answers to the database
-		if this.size < len(this.answers) {
-			for attempt := 0; attempt < 30; attempt++ {
-				if e := this.insert(this.answers); e == nil {
-					this.answers = this.answers[:0] //([]Answer, 0)
-					return
-				} else {
-					fmt.Fprintln(os.Stderr, e)
-					time.Sleep(2 * time.Second)
-				}
+		for _, chain := range chains {
+			hops, e := json.Marshal(chain.Hops)
+			if e != nil {
+				return e
+			}
+			if _, e := insert.Exec(chain.Time.Format(SQLITETIMEFORMAT), chain.Id, chain.Name, chain.Target, string(hops)); e != nil {
+				return e
 			}
-			fatalln("Failed to insert message into database")
 		}
 	}
+	return nil
 }
 
-func (this *resolverResponseSqliteMessageHandler) Close() {
-	if 0 < len(this.answers) {
-		for attempt := 0; attempt < 8; attempt++ {
-			if e := this.insert(this.answers); e == nil {
-				this.answers = this.answers[:0]
-				return
-			} else {
-				fmt.Fprintln(os.Stderr, e)
-			}
-		}
-		fatalln("Failed to insert message into database")
+// NewSqliteWriter creates the writer shared by the SQLite message handlers of
+// all workers. It writes the answers to the database, or to one database per
+// day or week (partition) named after the database, e.g. "pdns-2026-10-16.db"
+// or "pdns-2026-W42.db" for "pdns.db".
+func NewSqliteWriter(database string, partition string, size int, age time.Duration) *answerWriter {
+	fmt.Fprintf(os.Stderr, "Creating SQLite3 writer \"%v\"\n", database)
+
+	this := &sqliteWriter{database: database, partition: partition, dbs: make(map[string]*sql.DB)}
+	if partition == PARTITIONNONE {
+		// create the database up front
+		this.db(time.Now())
 	}
-}
 
-func NewResolverResponseSqliteMessageHandler(database string, cache int) dnstapserver.DnstapMessageHandler {
-	fmt.Fprintf(os.Stdout, "Creating SQLite3 message handler \"%v\"\n", database)
+	return newAnswerWriter("SQLite3", this, STORETYPES, size, age)
+}
 
-	db, e := sql.Open("sqlite3", database)
+// open the database, creating the schema if needed
+func sqliteCreate(database string) *sql.DB {
+	// incremental auto vacuum (only effective on new databases) lets the retention return freed
+	// pages, write ahead logging lets readers (queries) proceed while writing
+	db, e := sql.Open(SQLITEDRIVER, dsn(database, "_auto_vacuum=incremental&_journal_mode=WAL&_synchronous=NORMAL&_cache_size=-65536&_busy_timeout=10000"))
 	if e != nil {
 		fatalln(e)
 	}
+	// there is a single writer
+	db.SetMaxOpenConns(1)
 
 	if _, e := db.Exec(`CREATE TABLE IF NOT EXISTS answers (
 		time TEXT NOT NULL,
 		id INTEGER NOT NULL,
 		name TEXT NOT NULL,
+		rname TEXT NOT NULL,
 		ttl INTEGER NOT NULL,
 		class TEXT NOT NULL,
 		type TEXT NOT NULL,
 		data TEXT NOT NULL,
+		address BLOB,
+		qname TEXT,
+		qtype TEXT,
+		qclass TEXT,
 		UNIQUE (time, id, name, ttl, class, type, data)
 	);`); e != nil {
 		fatalln(e)
 	}
 
-	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_answers_name ON answers(name);`); e != nil {
+	// names are case insensitive, replaces the (binary) name index of databases created before
+	if _, e := db.Exec(`DROP INDEX IF EXISTS idx_answers_name;`); e != nil {
+		fatalln(e)
+	}
+
+	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_answers_name_nocase ON answers(name COLLATE NOCASE);`); e != nil {
 		fatalln(e)
 	}
 
@@ -302,5 +532,64 @@ func NewResolverResponseSqliteMessageHandler(database string, cache int) dnstaps
 		fatalln(e)
 	}
 
-	return &resolverResponseSqliteMessageHandler{db: db, size: cache, answers: make([]Answer, 0)}
+	// the retention deletes ranges of time
+	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_answers_time ON answers(time);`); e != nil {
+		fatalln(e)
+	}
+
+	// databases created before columns were added
+	for _, column := range SQLITEADDED {
+		var count int
+		if e := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('answers') WHERE name = ?;`, column.name).Scan(&count); e != nil {
+			fatalln(e)
+		}
+		if count == 0 {
+			fmt.Fprintf(os.Stderr, "Adding column %v to SQLite3 database \"%v\"\n", column.name, database)
+			if _, e := db.Exec(fmt.Sprintf(`ALTER TABLE answers ADD COLUMN %s %s;`, column.name, column.definition)); e != nil {
+				fatalln(e)
+			}
+			if column.value != "NULL" {
+				if _, e := db.Exec(fmt.Sprintf(`UPDATE answers SET %s = %s;`, column.name, column.value)); e != nil {
+					fatalln(e)
+				}
+			}
+		}
+	}
+
+	// suffix and left-hand wildcard lookups are ranges of reversed names
+	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_answers_rname ON answers(rname);`); e != nil {
+		fatalln(e)
+	}
+
+	// network lookups are ranges of addresses
+	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_answers_address ON answers(address) WHERE address IS NOT NULL;`); e != nil {
+		fatalln(e)
+	}
+
+	// the CNAME chains of the responses (see cnameChains), the hops are a JSON array
+	if _, e := db.Exec(`CREATE TABLE IF NOT EXISTS chains (
+		time TEXT NOT NULL,
+		id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		target TEXT NOT NULL,
+		hops TEXT NOT NULL,
+		UNIQUE (time, id, name)
+	);`); e != nil {
+		fatalln(e)
+	}
+
+	// names are case insensitive, replaces the (binary) name index of databases created before
+	if _, e := db.Exec(`DROP INDEX IF EXISTS idx_chains_name;`); e != nil {
+		fatalln(e)
+	}
+
+	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_chains_name_nocase ON chains(name COLLATE NOCASE);`); e != nil {
+		fatalln(e)
+	}
+
+	if _, e := db.Exec(`CREATE INDEX IF NOT EXISTS idx_chains_target ON chains(target);`); e != nil {
+		fatalln(e)
+	}
+
+	return db
 }
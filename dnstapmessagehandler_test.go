@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dnstap "passivedns/dnstap"
+	"passivedns/pdns"
+
+	"github.com/miekg/dns"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// a resolver response to a query of the first record's name and type, with
+// the records (in zone file format) as answers
+func testMessage(tb testing.TB, seen time.Time, records ...string) *dnstap.Message {
+	response := new(dns.Msg)
+	for _, record := range records {
+		rr, e := dns.NewRR(record)
+		if e != nil {
+			tb.Fatal(e)
+		}
+		response.Answer = append(response.Answer, rr)
+	}
+	query := new(dns.Msg)
+	query.SetQuestion(response.Answer[0].Header().Name, response.Answer[0].Header().Rrtype)
+	response.SetReply(query)
+
+	packed, e := response.Pack()
+	if e != nil {
+		tb.Fatal(e)
+	}
+	return &dnstap.Message{
+		Type:             dnstap.Message_RESOLVER_RESPONSE.Enum(),
+		ResponseTimeSec:  protobuf.Uint64(uint64(seen.Unix())),
+		ResponseTimeNsec: protobuf.Uint32(uint32(seen.Nanosecond())),
+		ResponseMessage:  packed}
+}
+
+// resolver responses with an A record of a distinct name each
+func benchmarkMessages(b *testing.B, count int) []*dnstap.Message {
+	seen := time.Now()
+	messages := make([]*dnstap.Message, 0, count)
+	for i := 0; i < count; i++ {
+		messages = append(messages, testMessage(b, seen, fmt.Sprintf("h%d.bench.example.com. 300 IN A 10.%d.%d.%d", i, i>>16&255, i>>8&255, i&255)))
+	}
+	return messages
+}
+
+// sustained inserts of the shared SQLite writer fed by several workers, the
+// time includes the final flush
+func BenchmarkSqliteWriter(b *testing.B) {
+	messages := benchmarkMessages(b, b.N)
+	benchmarkWriter(b, NewSqliteWriter(filepath.Join(b.TempDir(), "pdns.db"), PARTITIONNONE, 10000, 5*time.Second), messages)
+}
+
+// feed the messages to a shared writer from several workers and report the
+// answers written per second
+func benchmarkWriter(b *testing.B, writer *answerWriter, messages []*dnstap.Message) {
+	const workers = 4
+
+	handlers := make([]interface {
+		Handle(message *dnstap.Message)
+		Close()
+	}, workers)
+	for i := range handlers {
+		handlers[i] = NewResolverResponseOutputMessageHandler(writer)
+	}
+
+	b.ResetTimer()
+	started := time.Now()
+
+	var group sync.WaitGroup
+	for i := range handlers {
+		group.Add(1)
+		go func(worker int) {
+			defer group.Done()
+			for j := worker; j < len(messages); j += workers {
+				handlers[worker].Handle(messages[j])
+			}
+		}(i)
+	}
+	group.Wait()
+	for _, handler := range handlers {
+		handler.Close()
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(len(messages))/time.Since(started).Seconds(), "answers/s")
+}
+
+// outputResult records the answers written to an output
+type outputResult struct {
+	batches    [][]Answer
+	references int
+}
+
+func (this *outputResult) write(answers []Answer) {
+	this.batches = append(this.batches, answers)
+}
+
+func (this *outputResult) acquire() {
+	this.references++
+}
+
+func (this *outputResult) release() {
+	this.references--
+}
+
+// backendResult records the answers inserted by a writer
+type backendResult struct {
+	answers []Answer
+}
+
+func (this *backendResult) insert(answers []Answer) error {
+	this.answers = append(this.answers, answers...)
+	return nil
+}
+
+func (this *backendResult) close() {
+}
+
+// a message is unpacked once, the outputs share its answers and the store
+// writer keeps its types without modifying them
+func TestResolverResponseOutputMessageHandler(t *testing.T) {
+	backend := &backendResult{}
+	writer := newAnswerWriter("test", backend, STORETYPES, 1000, time.Minute)
+	first, second := &outputResult{}, &outputResult{}
+	handler := NewResolverResponseOutputMessageHandler(writer, first, second)
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler.Handle(testMessage(t, seen, "www.example.com. 300 IN TXT \"v=spf1 -all\"", "www.example.com. 300 IN A 192.0.2.1", "www.example.com. 300 IN MX 10 mail.example.com."))
+	handler.Close()
+
+	if first.references != 0 || second.references != 0 {
+		t.Errorf("got %v and %v reference(s), expected the outputs to be released", first.references, second.references)
+	}
+	if len(first.batches) != 1 || len(second.batches) != 1 || &first.batches[0][0] != &second.batches[0][0] {
+		t.Fatalf("got %v and %v, expected the same answers", first.batches, second.batches)
+	}
+	types := []string{}
+	for _, answer := range first.batches[0] {
+		types = append(types, dns.TypeToString[answer.Type])
+	}
+	if fmt.Sprint(types) != "[TXT A MX]" {
+		t.Errorf("got %v, expected the answers of the message", types)
+	}
+	if len(backend.answers) != 1 || backend.answers[0].Type != dns.TypeA || backend.answers[0].Data != "192.0.2.1" {
+		t.Errorf("got %v, expected the A record", backend.answers)
+	}
+}
+
+// the question of the response is in each answer, in all encodings
+func TestAnswerQuestion(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	found, e := answers(testMessage(t, seen, "WWW.example.com. 300 IN CNAME web.example.net.", "web.example.net. 300 IN A 192.0.2.1"), []uint16{})
+	if e != nil || len(found) != 2 {
+		t.Fatalf("got %v (%v), expected 2 answers", found, e)
+	}
+	answer := found[1]
+
+	packed, e := protobuf.Marshal(answer.Protobuf())
+	if e != nil {
+		t.Fatal(e)
+	}
+	decoded := new(pdns.Answer)
+	if e := protobuf.Unmarshal(packed, decoded); e != nil {
+		t.Fatal(e)
+	}
+	if question := fmt.Sprintf("%v %v %v %v", decoded.Name, decoded.QuestionName, decoded.QuestionType, decoded.QuestionClass); question != "web.example.net WWW.example.com CNAME IN" {
+		t.Errorf("got protobuf %v", question)
+	}
+
+	if json, ok := answer.Json(); !ok || !strings.Contains(json, `"qname":"WWW.example.com","qtype":[5,"CNAME"],"qclass":[1,"IN"]`) {
+		t.Errorf("got JSON %v", json)
+	}
+
+	// responses without a question leave it empty
+	answer.QuestionName = ""
+	if decoded := answer.Protobuf(); decoded.QuestionName != "" || decoded.QuestionType != "" || decoded.QuestionClass != "" {
+		t.Errorf("got the question %v %v %v", decoded.QuestionName, decoded.QuestionType, decoded.QuestionClass)
+	}
+}
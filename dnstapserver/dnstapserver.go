@@ -3,6 +3,7 @@ package dnstapserver
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"sync"
 	"time"
@@ -26,8 +27,42 @@ type DnstapMessageHandler interface {
 	Close()
 }
 
+// DnstapEnvelopeHandler is implemented by message handlers that also need
+// the Dnstap envelope of the message, e.g. the identity of the sensor (DNS
+// server). Handle is not called for these handlers.
+type DnstapEnvelopeHandler interface {
+	HandleEnvelope(envelope *dnstap.Dnstap)
+}
+
+// DnstapFrameHandler receives the raw (still encoded) Dnstap frames, in the
+// order they were read from an input, before they are handed to the workers.
+// HandleFrame must not block and must not modify the frame.
+type DnstapFrameHandler interface {
+	HandleFrame(frame []byte)
+	Close()
+}
+
+// ReaderOptions configures how frames are read from a single input
+// (connection or file).
+type ReaderOptions struct {
+	// Peer identifies the input in log messages, e.g. the remote address
+	Peer string
+	// Bidirectional enables the Frame Streams handshake (connections only)
+	Bidirectional bool
+	// HandshakeTimeout limits the time spent on the Frame Streams handshake
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes connections that have not sent a frame for this
+	// long, zero disables the timeout
+	IdleTimeout time.Duration
+	// MaxFrameSize is the upper limit on frame sizes, larger frames are
+	// discarded, zero means MAXFRAMESIZE
+	MaxFrameSize uint32
+}
+
 type DnstapServer interface {
-	Read(input io.Reader, bidrectional bool, timeout time.Duration)
+	Read(input io.Reader, options ReaderOptions) <-chan struct{}
+	Dial(network string, address string, options ReaderOptions)
+	Inject(message *dnstap.Dnstap) error
 	Stop()
 	Wait()
 }
@@ -59,7 +94,9 @@ func (this *dnstapworker) listen(pipe <-chan []byte) {
 		if e := protobuf.Unmarshal(frame, &dns); e == nil {
 			if *dns.Type == dnstap.Dnstap_MESSAGE && dns.Message != nil && this.handlers != nil && 0 < len(this.handlers) {
 				for _, handler := range this.handlers {
-					if handler != nil {
+					if envelope, ok := handler.(DnstapEnvelopeHandler); ok {
+						envelope.HandleEnvelope(&dns)
+					} else if handler != nil {
 						handler.Handle(dns.Message)
 					}
 				}
@@ -69,12 +106,16 @@ func (this *dnstapworker) listen(pipe <-chan []byte) {
 			break
 		}
 	}
+
+	// the pipe is drained (or broken), let the handlers flush and release their resources
+	this.Stop()
+
 	fmt.Fprintf(os.Stderr, "Dnstap %v worker thread terminated\n", this.id)
 }
 
-func New(workers int, queue int, handlers func(worker DnstapWorker) []DnstapMessageHandler) DnstapServer {
+func New(workers int, queue int, handlers func(worker DnstapWorker) []DnstapMessageHandler, frames []DnstapFrameHandler) DnstapServer {
 	fmt.Fprintln(os.Stderr, "Creating Dnstap server")
-	server := &dnstapserver{wg: new(sync.WaitGroup), pipe: make(chan []byte, queue), mutex: new(sync.RWMutex), running: true, workers: make([]DnstapWorker, 0, workers)}
+	server := &dnstapserver{wg: new(sync.WaitGroup), pipe: make(chan []byte, queue), mutex: new(sync.RWMutex), running: true, stopped: make(chan struct{}), workers: make([]DnstapWorker, 0, workers), frames: frames}
 
 	fmt.Fprintf(os.Stderr, "Spawning %v Dnstap worker thread(s)\n", workers)
 	for i := 0; i < workers; i++ {
@@ -98,12 +139,15 @@ type dnstapserver struct {
 	wg      *sync.WaitGroup
 	mutex   *sync.RWMutex
 	running bool
+	stopped chan struct{}
 	pipe    chan []byte
 	workers []DnstapWorker
+	frames  []DnstapFrameHandler
 }
 
-// MaxFrameSize sets the upper limit on input Dnstap payload (frame) sizes. If an Input
-// receives a Dnstap payload over this size limit, ReadInto will log an error and return.
+// MAXFRAMESIZE is the default upper limit on input Dnstap payload (frame) sizes, see
+// ReaderOptions.MaxFrameSize. If an input receives a Dnstap payload over this size
+// limit, the server thread will log an error and discard the payload.
 //
 // EDNS0 and DNS over TCP use 2 octets for DNS message size, imposing a maximum
 // size of 65535 octets for the DNS message, which is the bulk of the data carried
@@ -113,9 +157,25 @@ type dnstapserver struct {
 // allows a bit over 30KB space for "extra" metadata.
 const MAXFRAMESIZE uint32 = 96 * 1024
 
-func (this *dnstapserver) redirect(reader *framestream.Reader, pipe chan<- []byte) {
-	buffer := make([]byte, MAXFRAMESIZE)
+// MAXFRAMESIZELIMIT is the upper bound for ReaderOptions.MaxFrameSize, a buffer
+// of the size is allocated per input (connection).
+const MAXFRAMESIZELIMIT uint32 = 16 * 1024 * 1024
+
+func (this *dnstapserver) redirect(reader *framestream.Reader, input io.Reader, options ReaderOptions, pipe chan<- []byte, done chan<- struct{}) {
+	defer close(done)
+
+	// the idle timeout only applies to connections
+	connection, _ := input.(net.Conn)
+	if options.IdleTimeout == 0 {
+		connection = nil
+	}
+
+	buffer := make([]byte, options.MaxFrameSize)
 	for this.running {
+		if connection != nil {
+			connection.SetReadDeadline(time.Now().Add(options.IdleTimeout))
+		}
+
 		if length, e := reader.ReadFrame(buffer); e == nil {
 			frame := make([]byte, length)
 			if copy(frame, buffer) != length {
@@ -125,35 +185,149 @@ func (this *dnstapserver) redirect(reader *framestream.Reader, pipe chan<- []byt
 			this.mutex.RLock()
 			if this.running {
 				// write dnstap frame to channel
-				pipe <- frame
+				this.push(pipe, frame)
 			}
 			this.mutex.RUnlock()
+		} else if e == framestream.ErrDataFrameTooLarge {
+			// the frame has been discarded, the stream is still in sync
+			fmt.Fprintf(os.Stderr, "Dnstap peer %v sent a frame exceeding the %v bytes limit, frame discarded\n", options.Peer, options.MaxFrameSize)
 		} else {
-			if e != io.EOF {
-				fmt.Fprintf(os.Stderr, "Dnstap server thread encountered the following unexpected error \"%v\"\n", e)
+			if timeout, ok := e.(net.Error); ok && timeout.Timeout() && connection != nil {
+				fmt.Fprintf(os.Stderr, "Dnstap peer %v has been idle for more than %v, closing the connection\n", options.Peer, options.IdleTimeout)
+			} else if e != io.EOF {
+				fmt.Fprintf(os.Stderr, "Dnstap server thread (peer %v) encountered the following unexpected error \"%v\"\n", options.Peer, e)
 			}
 			break
 		}
 	}
-	fmt.Fprintln(os.Stderr, "Dnstap server thread terminated")
+	fmt.Fprintf(os.Stderr, "Dnstap server thread (peer %v) terminated\n", options.Peer)
 }
 
 const CONTENT_TYPE_PROTOBUF_DNSTAP = "protobuf:dnstap.Dnstap"
 
-func (this *dnstapserver) Read(input io.Reader, bidirectional bool, timeout time.Duration) {
+// start reading frames from the input in a separate thread, the returned
+// channel is closed when the input is exhausted (or fails)
+func (this *dnstapserver) read(input io.Reader, options ReaderOptions) (<-chan struct{}, error) {
 	this.mutex.RLock()
 	defer this.mutex.RUnlock()
 
-	if this.running {
-		if stream, e := framestream.NewReader(input, &framestream.ReaderOptions{ContentTypes: [][]byte{[]byte(CONTENT_TYPE_PROTOBUF_DNSTAP)}, Bidirectional: bidirectional, Timeout: timeout}); e == nil {
-			fmt.Fprintln(os.Stderr, "Spawning Dnstap server thread")
-			spawn(func() { this.redirect(stream, this.pipe) }, this.wg)
-		} else {
-			panic(e)
+	if !this.running {
+		return nil, fmt.Errorf("Dnstap server is stopped (closed)")
+	}
+
+	if options.MaxFrameSize == 0 {
+		options.MaxFrameSize = MAXFRAMESIZE
+	} else if MAXFRAMESIZELIMIT < options.MaxFrameSize {
+		options.MaxFrameSize = MAXFRAMESIZELIMIT
+	}
+
+	stream, e := framestream.NewReader(input, &framestream.ReaderOptions{ContentTypes: [][]byte{[]byte(CONTENT_TYPE_PROTOBUF_DNSTAP)}, Bidirectional: options.Bidirectional, Timeout: options.HandshakeTimeout})
+	if e != nil {
+		if timeout, ok := e.(net.Error); ok && timeout.Timeout() {
+			return nil, fmt.Errorf("Frame Streams handshake with peer %v timed out after %v", options.Peer, options.HandshakeTimeout)
+		}
+		return nil, fmt.Errorf("Frame Streams handshake with peer %v failed: %v", options.Peer, e)
+	}
+
+	fmt.Fprintf(os.Stderr, "Spawning Dnstap server thread (peer %v)\n", options.Peer)
+	done := make(chan struct{})
+	spawn(func() { this.redirect(stream, input, options, this.pipe, done) }, this.wg)
+	return done, nil
+}
+
+// Read frames from the input in a separate thread, the returned channel is
+// closed when the input is exhausted, fails or violates the options.
+func (this *dnstapserver) Read(input io.Reader, options ReaderOptions) <-chan struct{} {
+	done, e := this.read(input, options)
+	if e != nil {
+		fmt.Fprintln(os.Stderr, e)
+
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return done
+}
+
+// upper limit for the reconnect backoff of outbound connections
+const MAXBACKOFF = time.Minute
+
+// Dial connects to a remote Frame Streams writer (e.g. a DNS server acting as
+// a Frame Streams listener) and reads frames from it as the reader end of a
+// bidirectional stream. The connection is re-established, with exponential
+// backoff, whenever it fails or the stream ends, until the server is stopped.
+func (this *dnstapserver) Dial(network string, address string, options ReaderOptions) {
+	options.Bidirectional = true
+	if options.Peer == "" {
+		options.Peer = fmt.Sprintf("\"%v:%v\"", network, address)
+	}
+
+	spawn(func() {
+		backoff := time.Second
+		for {
+			if connection, e := net.DialTimeout(network, address, options.HandshakeTimeout); e == nil {
+				if done, e := this.read(connection, options); e == nil {
+					fmt.Fprintf(os.Stderr, "Connected to Dnstap endpoint \"%v:%v\"\n", network, address)
+					backoff = time.Second
+
+					select {
+					case <-done:
+						fmt.Fprintf(os.Stderr, "Connection to Dnstap endpoint \"%v:%v\" closed\n", network, address)
+						connection.Close()
+					case <-this.stopped:
+						// unblock the server thread
+						connection.Close()
+						<-done
+						return
+					}
+				} else {
+					fmt.Fprintln(os.Stderr, e)
+					connection.Close()
+				}
+			} else {
+				fmt.Fprintf(os.Stderr, "Failed to connect to Dnstap endpoint \"%v:%v\": %v\n", network, address, e)
+			}
+
+			select {
+			case <-time.After(backoff):
+			case <-this.stopped:
+				return
+			}
+
+			if backoff *= 2; MAXBACKOFF < backoff {
+				backoff = MAXBACKOFF
+			}
 		}
-	} else {
-		panic("Dnstap server is stopped (closed)\n")
+	}, this.wg)
+}
+
+// Inject writes a Dnstap message that did not arrive over Frame Streams (e.g.
+// synthesized from a packet capture) to the pipe, as if it had been read from
+// an input.
+func (this *dnstapserver) Inject(message *dnstap.Dnstap) error {
+	frame, e := protobuf.Marshal(message)
+	if e != nil {
+		return e
+	}
+
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	if !this.running {
+		return fmt.Errorf("Dnstap server is stopped (closed)")
+	}
+
+	this.push(this.pipe, frame)
+	return nil
+}
+
+// pass the frame to the frame handlers and write it to the pipe, the caller
+// must hold the (read) lock and the server must be running
+func (this *dnstapserver) push(pipe chan<- []byte, frame []byte) {
+	for _, handler := range this.frames {
+		handler.HandleFrame(frame)
 	}
+	pipe <- frame
 }
 
 func (this *dnstapserver) Stop() {
@@ -163,12 +337,18 @@ func (this *dnstapserver) Stop() {
 	if this.running {
 		fmt.Fprintln(os.Stderr, "Stopping the Dnstap server")
 
-		for _, worker := range this.workers {
-			worker.Stop()
-		}
-
 		this.running = false
+		close(this.stopped)
+
+		// no more frames will be pushed (we hold the write lock), the frame
+		// handlers may take a while to flush so close them in a thread
+		spawn(func() {
+			for _, handler := range this.frames {
+				handler.Close()
+			}
+		}, this.wg)
 
+		// the workers stop (close) their message handlers once the pipe is drained
 		close(this.pipe)
 		//this.pipe = nil
 
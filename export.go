@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// export the answers of a SQLite database (or all of its partitions) to a
+// single Parquet file, "passivedns export -sqlite pdns.db -parquet pdns.parquet"
+func export(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	sqlite := flags.String("sqlite", "", "SQLite3 database to export")
+	partition := flags.String("sqlite-partition", PARTITIONNONE, "Export all day or week partitions of the SQLite3 database [day, week]")
+	output := flags.String("parquet", "", "Parquet file to write")
+	compression := flags.String("parquet-compression", "snappy", "Parquet compression [snappy, gzip, zstd, none]")
+	flags.Parse(args)
+
+	if *sqlite == "" || *output == "" {
+		fatalln("Missing argument -sqlite <database> or -parquet <file>")
+	}
+	if *partition != PARTITIONNONE && *partition != PARTITIONDAY && *partition != PARTITIONWEEK {
+		fatalf("Invalid argument -sqlite-partition %v, expected day or week\n", *partition)
+	}
+
+	codec, e := parquetCompression(*compression)
+	if e != nil {
+		fatalln(e)
+	}
+
+	files, e := partitionFiles(*sqlite, *partition, time.Time{}, time.Time{})
+	if e != nil {
+		fatalln(e)
+	}
+
+	parquet, e := parquetCreate(*output, codec)
+	if e != nil {
+		fatalln(e)
+	}
+
+	count := 0
+	for _, file := range files {
+		fmt.Fprintf(os.Stderr, "Exporting SQLite3 database \"%v\"\n", file)
+		if exported, e := exportSqlite(file, parquet); e == nil {
+			count += exported
+		} else {
+			os.Remove(parquet.abort())
+			fatalf("Failed to export \"%v\": %v\n", file, e)
+		}
+	}
+
+	if e := parquet.close(); e != nil {
+		fatalln(e)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %v answer(s) to Parquet file \"%v\"\n", count, *output)
+}
+
+func exportSqlite(file string, parquet *parquetFile) (int, error) {
+	db, e := sql.Open("sqlite3", dsn(file, "_query_only=1"))
+	if e != nil {
+		return 0, e
+	}
+	defer db.Close()
+
+	rows, e := db.Query("SELECT time, id, name, ttl, class, type, data FROM answers ORDER BY time")
+	if e != nil {
+		return 0, e
+	}
+	defer rows.Close()
+
+	count := 0
+	answers := make([]Answer, 0, 1024)
+	for rows.Next() {
+		var answer Answer
+		var seen, class, rrtype string
+		if e := rows.Scan(&seen, &answer.Id, &answer.Name, &answer.Ttl, &class, &rrtype, &answer.Data); e != nil {
+			return count, e
+		}
+		answer.Time, _ = time.ParseInLocation(SQLITETIMEFORMAT, seen, time.Local)
+		answer.Class = dns.StringToClass[class]
+		answer.Type = dns.StringToType[rrtype]
+
+		if answers = append(answers, answer); len(answers) == cap(answers) {
+			if e := parquet.write(answers); e != nil {
+				return count, e
+			}
+			count += len(answers)
+			answers = answers[:0]
+		}
+	}
+	if e := rows.Err(); e != nil {
+		return count, e
+	}
+
+	if e := parquet.write(answers); e != nil {
+		return count, e
+	}
+	return count + len(answers), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// subscriber receives the answers matching its filter, answers that do not
+// fit in its buffer are dropped (and counted) rather than stalling the workers
+type subscriber struct {
+	filter  func(answer *Answer) bool
+	answers chan Answer
+	dropped uint64
+}
+
+// feed distributes the answers of all workers to the live subscribers, e.g.
+// the gRPC streams
+type feed struct {
+	mutex       *sync.RWMutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+func newFeed() *feed {
+	return &feed{mutex: new(sync.RWMutex), subscribers: make(map[*subscriber]struct{})}
+}
+
+// subscribe to the answers matching the filter (nil matches all), the
+// channel is closed when the feed is closed
+func (this *feed) subscribe(filter func(answer *Answer) bool, buffer int) *subscriber {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	subscriber := &subscriber{filter: filter, answers: make(chan Answer, buffer)}
+	if this.closed {
+		close(subscriber.answers)
+	} else {
+		this.subscribers[subscriber] = struct{}{}
+	}
+	return subscriber
+}
+
+func (this *feed) unsubscribe(subscriber *subscriber) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.subscribers[subscriber]; ok {
+		delete(this.subscribers, subscriber)
+		close(subscriber.answers)
+	}
+}
+
+func (this *feed) write(answers []Answer) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	for subscriber := range this.subscribers {
+		for i := range answers {
+			if subscriber.filter == nil || subscriber.filter(&answers[i]) {
+				select {
+				case subscriber.answers <- answers[i]:
+				default:
+					atomic.AddUint64(&subscriber.dropped, 1)
+				}
+			}
+		}
+	}
+}
+
+// close the channels of all subscribers
+func (this *feed) close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for subscriber := range this.subscribers {
+		close(subscriber.answers)
+	}
+	this.subscribers = make(map[*subscriber]struct{})
+	this.closed = true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// forwarderConnection writes items (e.g. frames) to a downstream endpoint
+type forwarderConnection interface {
+	write(item []byte) error
+	flush() error
+	// end the stream once the forwarder is closed, e.g. with a stop frame
+	finish()
+	close()
+}
+
+// forwarder sends items (Dnstap frames for the relay, messages for syslog) to
+// a downstream endpoint, reconnecting with backoff. Items are buffered while
+// (re)connecting, items that do not fit in the buffer are dropped (and
+// counted) rather than stalling the input.
+type forwarder struct {
+	name    string
+	kind    string
+	network string
+	address string
+	connect func() (forwarderConnection, string, error)
+
+	items   chan []byte
+	stop    chan struct{}
+	done    chan struct{}
+	dropped uint64
+}
+
+func (this *forwarder) send(item []byte) {
+	select {
+	case this.items <- item:
+	default:
+		atomic.AddUint64(&this.dropped, 1)
+	}
+}
+
+func (this *forwarder) close() {
+	close(this.stop)
+	close(this.items)
+	<-this.done
+	if dropped := atomic.LoadUint64(&this.dropped); 0 < dropped {
+		fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" dropped %v %v(s)\n", this.name, this.network, this.address, dropped, this.kind)
+	}
+}
+
+// write items to the connection until the forwarder is closed (true) or the
+// connection fails (false), an item that could not be written is returned
+func (this *forwarder) forward(connection forwarderConnection, pending []byte) ([]byte, bool) {
+	for {
+		if pending == nil {
+			var ok bool
+			if pending, ok = <-this.items; !ok {
+				if e := connection.flush(); e != nil {
+					fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" failed: %v\n", this.name, this.network, this.address, e)
+				}
+				connection.finish()
+				return nil, true
+			}
+		}
+
+		if e := connection.write(pending); e != nil {
+			fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" failed: %v\n", this.name, this.network, this.address, e)
+			return pending, false
+		}
+		pending = nil
+
+		// flush once the buffer is drained
+		if len(this.items) == 0 {
+			if e := connection.flush(); e != nil {
+				fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" failed: %v\n", this.name, this.network, this.address, e)
+				return nil, false
+			}
+		}
+	}
+}
+
+func (this *forwarder) run() {
+	defer close(this.done)
+
+	var pending []byte
+	backoff := time.Second
+	for {
+		if connection, network, e := this.connect(); e == nil {
+			fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" connected\n", this.name, network, this.address)
+			backoff = time.Second
+
+			var closed bool
+			pending, closed = this.forward(connection, pending)
+			connection.close()
+			if closed {
+				return
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" failed to connect: %v\n", this.name, this.network, this.address, e)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-this.stop:
+			fmt.Fprintf(os.Stderr, "%v to \"%v:%v\" closed while disconnected, %v buffered %v(s) discarded\n", this.name, this.network, this.address, len(this.items), this.kind)
+			return
+		}
+
+		if backoff *= 2; RELAYMAXBACKOFF < backoff {
+			backoff = RELAYMAXBACKOFF
+		}
+	}
+}
+
+// create a forwarder of items of a kind (e.g. "frame", in messages) to the
+// endpoint, connect returns a connection and the network it uses
+func newForwarder(name string, kind string, network string, address string, buffer int, connect func() (forwarderConnection, string, error)) *forwarder {
+	this := &forwarder{name: name, kind: kind, network: network, address: address, connect: connect, items: make(chan []byte, buffer), stop: make(chan struct{}), done: make(chan struct{})}
+	go this.run()
+
+	return this
+}
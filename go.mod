@@ -3,9 +3,17 @@ module passivedns
 go 1.16
 
 require (
-	github.com/farsightsec/golang-framestream v0.3.0 // indirect
+	github.com/farsightsec/golang-framestream v0.3.0
 	github.com/google/uuid v1.2.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.7 // indirect
-	github.com/miekg/dns v1.1.42 // indirect
-	google.golang.org/protobuf v1.26.0 // indirect
+	github.com/lib/pq v1.10.2
+	github.com/mattn/go-sqlite3 v1.14.7
+	github.com/miekg/dns v1.1.42
+	github.com/nats-io/nats-server/v2 v2.3.0
+	github.com/nats-io/nats.go v1.11.0
+	github.com/segmentio/kafka-go v0.4.47
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+	go.etcd.io/bbolt v1.3.6
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	pdns "passivedns/pdns"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcOutput serves the answers to the subscribers of the PassiveDns service
+// (see pdns/pdns.proto), shared by the gRPC message handlers of all workers.
+// Each subscriber has a buffer of answers, answers are dropped for slow
+// subscribers rather than stalling the workers.
+type grpcOutput struct {
+	pdns.UnimplementedPassiveDnsServer
+
+	address string
+	server  *grpc.Server
+	feed    *feed
+	buffer  int
+
+	mutex      *sync.Mutex
+	references int
+}
+
+// the filter of a subscribe request, types and name suffixes
+func grpcFilter(request *pdns.SubscribeRequest) (func(answer *Answer) bool, error) {
+	types := make(map[uint16]bool)
+	for _, name := range request.Types {
+		if rrtype, ok := dns.StringToType[strings.ToUpper(name)]; ok {
+			types[rrtype] = true
+		} else {
+			return nil, fmt.Errorf("invalid type \"%v\"", name)
+		}
+	}
+
+	suffixes := []string{}
+	for _, suffix := range request.Suffixes {
+		if _, ok := dns.IsDomainName(suffix); !ok {
+			return nil, fmt.Errorf("invalid name suffix \"%v\"", suffix)
+		}
+		suffixes = append(suffixes, dns.Fqdn(suffix))
+	}
+
+	return func(answer *Answer) bool {
+		if 0 < len(types) && !types[answer.Type] {
+			return false
+		}
+		for _, suffix := range suffixes {
+			if dns.IsSubDomain(suffix, answer.Name) {
+				return true
+			}
+		}
+		return len(suffixes) == 0
+	}, nil
+}
+
+// Subscribe streams the answers matching the request until the subscriber
+// cancels or the output is closed
+func (this *grpcOutput) Subscribe(request *pdns.SubscribeRequest, stream pdns.PassiveDns_SubscribeServer) error {
+	filter, e := grpcFilter(request)
+	if e != nil {
+		return status.Error(codes.InvalidArgument, e.Error())
+	}
+
+	client := "-"
+	if remote, ok := peer.FromContext(stream.Context()); ok {
+		client = remote.Addr.String()
+	}
+	fmt.Fprintf(os.Stderr, "gRPC subscriber \"%v\" connected (types %v, suffixes %v)\n", client, request.Types, request.Suffixes)
+
+	subscriber := this.feed.subscribe(filter, this.buffer)
+	defer func() {
+		this.feed.unsubscribe(subscriber)
+		fmt.Fprintf(os.Stderr, "gRPC subscriber \"%v\" disconnected, %v answer(s) dropped\n", client, atomic.LoadUint64(&subscriber.dropped))
+	}()
+
+	for {
+		select {
+		case answer, ok := <-subscriber.answers:
+			if !ok {
+				return nil
+			}
+			if e := stream.Send(answer.Protobuf()); e != nil {
+				return e
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (this *grpcOutput) write(answers []Answer) {
+	this.feed.write(answers)
+}
+
+func (this *grpcOutput) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *grpcOutput) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		// ends the streams
+		this.feed.close()
+		this.server.GracefulStop()
+	}
+}
+
+// NewGrpcOutput creates the output shared by the gRPC message handlers of all
+// workers and serves it on address, e.g. "localhost:50051" or
+// "unix:/run/passivedns.sock", buffer is the number of answers buffered per
+// subscriber.
+func NewGrpcOutput(address string, buffer int) *grpcOutput {
+	fmt.Fprintf(os.Stderr, "Creating gRPC output \"%v\"\n", address)
+
+	network := "tcp"
+	if strings.HasPrefix(address, "unix:") {
+		network, address = "unix", strings.TrimPrefix(address, "unix:")
+	}
+
+	listener, e := net.Listen(network, address)
+	if e != nil {
+		fatalln(e)
+	}
+
+	output := &grpcOutput{address: address, server: grpc.NewServer(), feed: newFeed(), buffer: buffer, mutex: new(sync.Mutex)}
+	pdns.RegisterPassiveDnsServer(output.server, output)
+
+	go func() {
+		if e := output.server.Serve(listener); e != nil {
+			fmt.Fprintf(os.Stderr, "gRPC server \"%v\" failed: %v\n", address, e)
+		}
+	}()
+
+	return output
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// interval of the comments keeping idle event streams (and proxies) alive
+const HTTPKEEPALIVE = 15 * time.Second
+
+// httpOutput serves the HTTP API, shared by the HTTP message handlers of all
+// workers. /tail streams the answers matching the filters of the request as
+// Server-Sent Events, e.g. /tail?name=*.example.com&type=A&type=AAAA or
+// /tail?cidr=192.0.2.0/24. Each subscriber has a buffer of answers, answers
+// are dropped for slow subscribers rather than stalling the workers. With a
+// store, /query looks records up like the query subcommand, e.g.
+// /query?lookup=name&value=*.example.com&type=A&from=24h&format=cof, and
+// /pivot returns the graph of the records around a name or an address (of at
+// most PIVOTMAXNODES nodes), e.g.
+// /pivot?start=www.example.com&hops=3&fanout=20&from=24h&format=dot.
+type httpOutput struct {
+	address string
+	server  *http.Server
+	mux     *http.ServeMux
+	feed    *feed
+	buffer  int
+	store   Store
+
+	mutex      *sync.Mutex
+	references int
+}
+
+// the filter of a tail request, name globs, data CIDRs and types, values of
+// the same parameter match any
+func tailFilter(parameters map[string][]string) (func(answer *Answer) bool, error) {
+	names := []string{}
+	for _, name := range parameters["name"] {
+		name = strings.ToLower(strings.TrimRight(name, "."))
+		if _, e := path.Match(name, ""); e != nil {
+			return nil, fmt.Errorf("invalid name pattern \"%v\"", name)
+		}
+		names = append(names, name)
+	}
+
+	networks := []*net.IPNet{}
+	for _, cidr := range parameters["cidr"] {
+		if ip := net.ParseIP(cidr); ip != nil {
+			// a single address
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+		} else if _, network, e := net.ParseCIDR(cidr); e == nil {
+			networks = append(networks, network)
+		} else {
+			return nil, fmt.Errorf("invalid CIDR \"%v\"", cidr)
+		}
+	}
+
+	types := make(map[uint16]bool)
+	for _, name := range parameters["type"] {
+		if rrtype, ok := dns.StringToType[strings.ToUpper(name)]; ok {
+			types[rrtype] = true
+		} else {
+			return nil, fmt.Errorf("invalid type \"%v\"", name)
+		}
+	}
+
+	return func(answer *Answer) bool {
+		if 0 < len(types) && !types[answer.Type] {
+			return false
+		}
+
+		if 0 < len(names) {
+			name, matched := strings.ToLower(strings.TrimRight(answer.Name, ".")), false
+			for _, pattern := range names {
+				if matched, _ = path.Match(pattern, name); matched {
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+
+		if 0 < len(networks) {
+			if answer.Type != dns.TypeA && answer.Type != dns.TypeAAAA {
+				return false
+			}
+			ip := net.ParseIP(answer.Data)
+			for _, network := range networks {
+				if ip != nil && network.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}
+
+		return true
+	}, nil
+}
+
+// stream the matching answers as Server-Sent Events ("answer" events with the
+// JSON of the answer, "dropped" events with the number of dropped answers)
+func (this *httpOutput) tail(writer http.ResponseWriter, request *http.Request) {
+	filter, e := tailFilter(request.URL.Query())
+	if e != nil {
+		http.Error(writer, e.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "HTTP tail subscriber \"%v\" connected (%v)\n", request.RemoteAddr, request.URL.RawQuery)
+
+	subscriber := this.feed.subscribe(filter, this.buffer)
+	defer func() {
+		this.feed.unsubscribe(subscriber)
+		fmt.Fprintf(os.Stderr, "HTTP tail subscriber \"%v\" disconnected, %v answer(s) dropped\n", request.RemoteAddr, atomic.LoadUint64(&subscriber.dropped))
+	}()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(HTTPKEEPALIVE)
+	defer keepalive.Stop()
+
+	dropped := uint64(0)
+	for {
+		select {
+		case answer, ok := <-subscriber.answers:
+			if !ok {
+				return
+			}
+			if json, ok := answer.Json(); ok {
+				if _, e := fmt.Fprintf(writer, "event: answer\ndata: %s\n\n", json); e != nil {
+					return
+				}
+			}
+
+			// once the buffer is drained
+			if len(subscriber.answers) == 0 {
+				if count := atomic.LoadUint64(&subscriber.dropped); dropped < count {
+					fmt.Fprintf(writer, "event: dropped\ndata: %d\n\n", count-dropped)
+					dropped = count
+				}
+				flusher.Flush()
+			}
+		case <-keepalive.C:
+			if _, e := fmt.Fprint(writer, ": keepalive\n\n"); e != nil {
+				return
+			}
+			flusher.Flush()
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// the records of a lookup (see lookupRecords) or the resolutions of a name,
+// like the query subcommand, as JSON or COF
+func (this *httpOutput) query(writer http.ResponseWriter, request *http.Request) {
+	parameters := request.URL.Query()
+
+	lookup, value := parameters.Get("lookup"), parameters.Get("value")
+	if lookup == "" || value == "" {
+		http.Error(writer, "missing parameter lookup or value", http.StatusBadRequest)
+		return
+	}
+	format := parameters.Get("format")
+	switch format {
+	case "", QUERYJSON:
+		format = QUERYJSON
+		writer.Header().Set("Content-Type", "application/json")
+	case QUERYCOF:
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		http.Error(writer, "invalid format, expected json or cof", http.StatusBadRequest)
+		return
+	}
+
+	var query Query
+	var e error
+	if query.From, e = queryTime(parameters.Get("from")); e != nil {
+		http.Error(writer, e.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.To, e = queryTime(parameters.Get("to")); e != nil {
+		http.Error(writer, e.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, rrtype := range parameters["type"] {
+		query.Types = append(query.Types, strings.ToUpper(rrtype))
+	}
+
+	if lookup == "resolve" {
+		if resolutions, e := this.store.Resolve(value, query); e == nil {
+			printResolutions(writer, resolutions, format)
+		} else {
+			http.Error(writer, e.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if records, e := lookupRecords(this.store, lookup, value, query); e == nil {
+		printRecords(writer, records, format)
+	} else {
+		http.Error(writer, e.Error(), http.StatusBadRequest)
+	}
+}
+
+// the graph of the records around the start (see walkGraph) as JSON or DOT
+func (this *httpOutput) pivot(writer http.ResponseWriter, request *http.Request) {
+	parameters := request.URL.Query()
+
+	start := parameters.Get("start")
+	if start == "" {
+		http.Error(writer, "missing parameter start", http.StatusBadRequest)
+		return
+	}
+	hops, fanout := 2, 50
+	if value := parameters.Get("hops"); value != "" {
+		if n, e := strconv.Atoi(value); e == nil && 0 <= n && n <= PIVOTMAXHOPS {
+			hops = n
+		} else {
+			http.Error(writer, fmt.Sprintf("invalid hops \"%v\", expected 0 to %v", value, PIVOTMAXHOPS), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := parameters.Get("fanout"); value != "" {
+		if n, e := strconv.Atoi(value); e == nil && 0 < n && n <= PIVOTMAXFANOUT {
+			fanout = n
+		} else {
+			http.Error(writer, fmt.Sprintf("invalid fanout \"%v\", expected 1 to %v", value, PIVOTMAXFANOUT), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var query Query
+	var e error
+	if query.From, e = queryTime(parameters.Get("from")); e != nil {
+		http.Error(writer, e.Error(), http.StatusBadRequest)
+		return
+	}
+	if query.To, e = queryTime(parameters.Get("to")); e != nil {
+		http.Error(writer, e.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// bounded, and abandoned when the client goes away
+	graph, e := walkGraph(request.Context(), this.store, start, hops, fanout, PIVOTMAXNODES, query)
+	if e != nil {
+		if request.Context().Err() != nil {
+			return
+		}
+		http.Error(writer, e.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch parameters.Get("format") {
+	case "", GRAPHJSON:
+		writer.Header().Set("Content-Type", "application/json")
+		graph.Json(writer)
+	case GRAPHDOT:
+		writer.Header().Set("Content-Type", "text/vnd.graphviz")
+		graph.Dot(writer)
+	default:
+		http.Error(writer, "invalid format, expected json or dot", http.StatusBadRequest)
+	}
+}
+
+func (this *httpOutput) write(answers []Answer) {
+	this.feed.write(answers)
+}
+
+func (this *httpOutput) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *httpOutput) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		// ends the event streams
+		this.feed.close()
+
+		timeout, cancel := context.WithTimeout(context.Background(), RELAYTIMEOUT)
+		defer cancel()
+		if e := this.server.Shutdown(timeout); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to stop HTTP server \"%v\": %v\n", this.address, e)
+		}
+		if this.store != nil {
+			this.store.Close()
+		}
+	}
+}
+
+// NewHttpOutput creates the output shared by the HTTP message handlers of all
+// workers and serves the HTTP API on address, e.g. "localhost:8080", buffer is
+// the number of answers buffered per /tail subscriber and store (optional) is
+// queried by /query and /pivot.
+func NewHttpOutput(address string, buffer int, store Store) *httpOutput {
+	fmt.Fprintf(os.Stderr, "Creating HTTP output \"%v\"\n", address)
+
+	listener, e := net.Listen("tcp", address)
+	if e != nil {
+		fatalln(e)
+	}
+
+	output := &httpOutput{address: address, mux: http.NewServeMux(), feed: newFeed(), buffer: buffer, store: store, mutex: new(sync.Mutex)}
+	output.mux.HandleFunc("/tail", output.tail)
+	if store != nil {
+		output.mux.HandleFunc("/query", output.query)
+		output.mux.HandleFunc("/pivot", output.pivot)
+	}
+	output.server = &http.Server{Handler: output.mux, ReadHeaderTimeout: RELAYTIMEOUT}
+
+	go func() {
+		if e := output.server.Serve(listener); e != nil && e != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "HTTP server \"%v\" failed: %v\n", address, e)
+		}
+	}()
+
+	return output
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func tailAnswer(name string, rrtype uint16, data string) Answer {
+	return Answer{Time: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), Name: name, Ttl: 300, Class: dns.ClassINET, Type: rrtype, Data: data}
+}
+
+func TestTailFilter(t *testing.T) {
+	answers := []Answer{
+		tailAnswer("www.example.com.", dns.TypeA, "192.0.2.1"),
+		tailAnswer("WWW.Example.COM.", dns.TypeAAAA, "2001:db8::1"),
+		tailAnswer("web.example.com.", dns.TypeCNAME, "www.example.com."),
+		tailAnswer("mail.example.org.", dns.TypeA, "198.51.100.1"),
+		tailAnswer("example.com.", dns.TypeA, "192.0.2.2"),
+	}
+	tests := []struct {
+		query   string
+		matched string
+	}{
+		{"", "11111"},
+		{"name=*.example.com", "11100"},
+		{"name=www.example.com.", "11000"},
+		{"name=w??.example.com&name=mail.*", "11110"},
+		{"name=*.example.*", "11110"},
+		{"type=a", "10011"},
+		{"type=A&type=AAAA", "11011"},
+		{"cidr=192.0.2.0/24", "10001"},
+		{"cidr=192.0.2.1", "10000"},
+		{"cidr=2001:db8::/32&cidr=198.51.100.0/24", "01010"},
+		{"cidr=::/0", "01000"},
+		{"name=*.example.com&type=A&cidr=192.0.2.0/24", "10000"},
+		{"name=*.example.org&cidr=192.0.2.0/24", "00000"},
+	}
+	for _, test := range tests {
+		parameters, _ := url.ParseQuery(test.query)
+		filter, e := tailFilter(parameters)
+		if e != nil {
+			t.Errorf("%v: %v", test.query, e)
+			continue
+		}
+		matched := ""
+		for i := range answers {
+			matched += map[bool]string{false: "0", true: "1"}[filter(&answers[i])]
+		}
+		if matched != test.matched {
+			t.Errorf("%v: got %v, expected %v", test.query, matched, test.matched)
+		}
+	}
+
+	for _, query := range []string{"name=[www.example.com", "cidr=192.0.2.0/33", "cidr=www.example.com", "type=AA"} {
+		parameters, _ := url.ParseQuery(query)
+		if _, e := tailFilter(parameters); e == nil {
+			t.Errorf("%v: expected an error", query)
+		}
+	}
+}
+
+// tailEvents reads the Server-Sent Events of a tail
+type tailEvents struct {
+	reader *bufio.Reader
+}
+
+// the next event and its data, comments (keepalives) are skipped
+func (this *tailEvents) next(t *testing.T) (string, string) {
+	event, data := "", ""
+	for {
+		line, e := this.reader.ReadString('\n')
+		if e != nil {
+			t.Fatal(e)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// a tail of the output's HTTP API, once subscribed
+func tailTest(t *testing.T, output *httpOutput, server *httptest.Server, query string) (*http.Response, *tailEvents) {
+	response, e := http.Get(server.URL + "/tail?" + query)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %v %v", response.Status, response.Header.Get("Content-Type"))
+	}
+
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		output.feed.mutex.RLock()
+		subscribed = 0 < len(output.feed.subscribers)
+		output.feed.mutex.RUnlock()
+	}
+	return response, &tailEvents{reader: bufio.NewReader(response.Body)}
+}
+
+func TestHttpTail(t *testing.T) {
+	output := NewHttpOutput("127.0.0.1:0", 16, nil)
+	server := httptest.NewServer(output.mux)
+	defer server.Close()
+	output.acquire()
+	defer output.release()
+
+	if response, e := http.Get(server.URL + "/tail?cidr=192.0.2.0/33"); e != nil || response.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %v (%v), expected %v for an invalid filter", response.Status, e, http.StatusBadRequest)
+	}
+
+	_, events := tailTest(t, output, server, "name=*.example.com&type=A&type=AAAA&cidr=192.0.2.0/24&cidr=2001:db8::/32")
+	answers := []Answer{
+		tailAnswer("www.example.com.", dns.TypeA, "192.0.2.1"),
+		tailAnswer("www.example.com.", dns.TypeA, "198.51.100.1"),
+		tailAnswer("web.example.com.", dns.TypeCNAME, "www.example.com."),
+		tailAnswer("www.example.org.", dns.TypeA, "192.0.2.2"),
+		tailAnswer("WWW.Example.com.", dns.TypeAAAA, "2001:db8::1"),
+	}
+	output.write(answers)
+
+	for _, expected := range []Answer{answers[0], answers[4]} {
+		json, _ := expected.Json()
+		if event, data := events.next(t); event != "answer" || data != json {
+			t.Errorf("got %v %q, expected the answer %q", event, data, json)
+		}
+	}
+}
+
+// a subscriber which does not keep up misses answers, the workers do not wait
+// for it, and it is told how many it missed
+func TestHttpTailDropped(t *testing.T) {
+	const buffer, count = 4, 20000
+
+	output := NewHttpOutput("127.0.0.1:0", buffer, nil)
+	server := httptest.NewServer(output.mux)
+	defer server.Close()
+	output.acquire()
+	defer output.release()
+
+	_, events := tailTest(t, output, server, "type=TXT")
+	data := strings.Repeat("x", 1000)
+	started := time.Now()
+	for i := 0; i < count; i++ {
+		// one at a time, like the workers
+		output.write([]Answer{tailAnswer(fmt.Sprintf("h%d.example.com.", i), dns.TypeTXT, data)})
+	}
+	if elapsed := time.Since(started); 10*time.Second < elapsed {
+		t.Errorf("the writes waited %v for the subscriber", elapsed)
+	}
+
+	// all answers are either received or counted as dropped
+	received, dropped := 0, 0
+	for received+dropped < count {
+		switch event, data := events.next(t); event {
+		case "answer":
+			received++
+		case "dropped":
+			count, e := strconv.Atoi(data)
+			if e != nil || count <= 0 {
+				t.Fatalf("got %q dropped", data)
+			}
+			dropped += count
+		default:
+			t.Fatalf("got %v %q", event, data)
+		}
+	}
+	if received+dropped != count || dropped == 0 || received < buffer {
+		t.Errorf("received %v and dropped %v answer(s) of %v", received, dropped, count)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// encodings of the published answers
+const (
+	ENCODINGJSON     = "json"
+	ENCODINGPROTOBUF = "protobuf"
+)
+
+// kafkaProducer publishes messages, e.g. a kafka.Writer
+type kafkaProducer interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// kafkaOutput publishes a message per answer, keyed by name so all answers of
+// a name end up in the same partition, shared by the message handlers of all
+// workers. Messages are batched, compressed and delivered asynchronously by
+// the producer, deliveries and failures are counted.
+type kafkaOutput struct {
+	producer kafkaProducer
+	topic    string
+	encoding string
+
+	delivered uint64
+	failed    uint64
+
+	mutex      *sync.Mutex
+	references int
+}
+
+// encode the answer as JSON (as written by -json) or protobuf (pdns.Answer)
+func encodeAnswer(answer *Answer, encoding string) ([]byte, bool) {
+	if encoding == ENCODINGPROTOBUF {
+		if bytes, e := protobuf.Marshal(answer.Protobuf()); e == nil {
+			return bytes, true
+		} else {
+			fmt.Fprintf(os.Stderr, "protobuf.Marshal(...) failed: %s\n", e)
+			return nil, false
+		}
+	}
+	if json, ok := answer.Json(); ok {
+		return []byte(json), true
+	}
+	return nil, false
+}
+
+func (this *kafkaOutput) write(answers []Answer) {
+	messages := make([]kafka.Message, 0, len(answers))
+	for i := range answers {
+		if value, ok := encodeAnswer(&answers[i], this.encoding); ok {
+			// keyed by the lower cased name, the case variants (0x20) of a
+			// name share a partition
+			messages = append(messages, kafka.Message{Key: []byte(strings.ToLower(strings.TrimRight(answers[i].Name, "."))), Value: value})
+		}
+	}
+
+	if e := this.producer.WriteMessages(context.Background(), messages...); e != nil {
+		this.completion(messages, e)
+	}
+}
+
+// delivery report of the producer
+func (this *kafkaOutput) completion(messages []kafka.Message, e error) {
+	if e == nil {
+		atomic.AddUint64(&this.delivered, uint64(len(messages)))
+	} else {
+		if atomic.AddUint64(&this.failed, uint64(len(messages))) == uint64(len(messages)) {
+			// the first failure, the counts are reported when closed
+			fmt.Fprintf(os.Stderr, "Failed to publish to Kafka topic \"%v\": %v\n", this.topic, e)
+		}
+	}
+}
+
+func (this *kafkaOutput) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *kafkaOutput) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		// waits for the pending deliveries
+		if e := this.producer.Close(); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close Kafka producer: %v\n", e)
+		}
+		fmt.Fprintf(os.Stderr, "Kafka topic \"%v\" published %v answer(s), %v failed\n", this.topic, atomic.LoadUint64(&this.delivered), atomic.LoadUint64(&this.failed))
+	}
+}
+
+// kafka compression codec by name
+func kafkaCompression(name string) (kafka.Compression, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported Kafka compression \"%v\", expected gzip, snappy, lz4, zstd or none", name)
+	}
+}
+
+func newKafkaOutput(producer kafkaProducer, topic string, encoding string) *kafkaOutput {
+	return &kafkaOutput{producer: producer, topic: topic, encoding: encoding, mutex: new(sync.Mutex)}
+}
+
+// NewKafkaOutput creates the output shared by the Kafka message handlers of
+// all workers, brokers is a comma separated list of host:port.
+func NewKafkaOutput(brokers string, topic string, encoding string, compression string, batch int, timeout time.Duration) *kafkaOutput {
+	fmt.Fprintf(os.Stderr, "Creating Kafka output \"%v\" (%v)\n", topic, brokers)
+
+	codec, e := kafkaCompression(compression)
+	if e != nil {
+		fatalln(e)
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    batch,
+		BatchTimeout: timeout,
+		Compression:  codec,
+		RequiredAcks: kafka.RequireOne,
+		Async:        true}
+
+	output := newKafkaOutput(writer, topic, encoding)
+	writer.Completion = output.completion
+
+	return output
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dnstap "passivedns/dnstap"
+	dnstapserver "passivedns/dnstapserver"
+	"passivedns/pdns"
+
+	"github.com/segmentio/kafka-go"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// fakeProducer records the published messages and reports their delivery
+// like an asynchronous kafka.Writer, failing with the error if any
+type fakeProducer struct {
+	completion func(messages []kafka.Message, e error)
+	e          error
+	rejected   error
+
+	mutex    sync.Mutex
+	messages []kafka.Message
+	closed   bool
+}
+
+func (this *fakeProducer) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	if this.rejected != nil {
+		// failed before being queued, e.g. the writer was closed
+		return this.rejected
+	}
+	this.mutex.Lock()
+	this.messages = append(this.messages, messages...)
+	this.mutex.Unlock()
+	this.completion(messages, this.e)
+	return nil
+}
+
+func (this *fakeProducer) Close() error {
+	this.closed = true
+	return nil
+}
+
+// a Kafka output with a fake producer, the answers are partitioned by the
+// balancer of the kafka.Writer
+func kafkaTest(encoding string) (*kafkaOutput, *fakeProducer, kafka.Balancer) {
+	output := NewKafkaOutput("localhost:9092", "pdns", encoding, "none", 100, time.Second)
+	writer := output.producer.(*kafka.Writer)
+	writer.Close()
+
+	producer := &fakeProducer{completion: output.completion}
+	output.producer = producer
+	return output, producer, writer.Balancer
+}
+
+func TestKafkaPartitioning(t *testing.T) {
+	output, producer, balancer := kafkaTest(ENCODINGJSON)
+
+	handler := NewResolverResponseOutputMessageHandler(output)
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 32; i++ {
+		// two responses for each name, the second in another case (0x20)
+		handler.Handle(testMessage(t, seen,
+			fmt.Sprintf("h%d.example.com. 300 IN A 192.0.2.%d", i, i),
+			fmt.Sprintf("h%d.example.com. 300 IN AAAA 2001:db8::%d", i, i)))
+		handler.Handle(testMessage(t, seen, fmt.Sprintf("H%d.ExAmple.COM. 300 IN A 192.0.2.%d", i, i+100)))
+	}
+	handler.Close()
+
+	if len(producer.messages) != 3*32 || !producer.closed {
+		t.Fatalf("got %v message(s) (closed %v), expected %v", len(producer.messages), producer.closed, 3*32)
+	}
+
+	partitions := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	keys := map[string]int{}
+	used := map[int]bool{}
+	for _, message := range producer.messages {
+		var answer struct {
+			Name string `json:"name"`
+		}
+		if e := json.Unmarshal(message.Value, &answer); e != nil {
+			t.Fatal(e)
+		}
+		if string(message.Key) != strings.ToLower(answer.Name) {
+			t.Errorf("got key %q for %v", message.Key, answer.Name)
+		}
+
+		partition := balancer.Balance(message, partitions...)
+		if previous, ok := keys[string(message.Key)]; ok && previous != partition {
+			t.Errorf("%q published to partitions %v and %v", message.Key, previous, partition)
+		}
+		keys[string(message.Key)] = partition
+		used[partition] = true
+	}
+	if len(keys) != 32 {
+		t.Errorf("got %v keys, expected one for each of the 32 names", len(keys))
+	}
+	if len(used) < 2 {
+		t.Errorf("%v names published to %v partition(s)", len(keys), len(used))
+	}
+}
+
+func TestKafkaEncoding(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	for _, encoding := range []string{ENCODINGJSON, ENCODINGPROTOBUF} {
+		output, producer, _ := kafkaTest(encoding)
+
+		handler := NewResolverResponseOutputMessageHandler(output)
+		handler.(dnstapserver.DnstapEnvelopeHandler).HandleEnvelope(&dnstap.Dnstap{
+			Identity: []byte("resolver1"),
+			Message:  testMessage(t, seen, "www.example.com. 300 IN CNAME web.example.com.", "web.example.com. 60 IN A 192.0.2.1")})
+		handler.Close()
+
+		if len(producer.messages) != 2 {
+			t.Fatalf("%v: got %v message(s), expected 2", encoding, len(producer.messages))
+		}
+		expected := []*pdns.Answer{
+			{Name: "www.example.com", Ttl: 300, Class: "IN", Type: "CNAME", Data: "web.example.com"},
+			{Name: "web.example.com", Ttl: 60, Class: "IN", Type: "A", Data: "192.0.2.1"}}
+		for i, message := range producer.messages {
+			answer := &pdns.Answer{}
+			if encoding == ENCODINGPROTOBUF {
+				if e := protobuf.Unmarshal(message.Value, answer); e != nil {
+					t.Fatalf("%v: %v", encoding, e)
+				}
+			} else {
+				var decoded struct {
+					Time   time.Time     `json:"time"`
+					Sensor string        `json:"sensor"`
+					Name   string        `json:"name"`
+					Ttl    uint32        `json:"ttl"`
+					Class  []interface{} `json:"class"`
+					Type   []interface{} `json:"type"`
+					Data   string        `json:"data"`
+				}
+				if e := json.Unmarshal(message.Value, &decoded); e != nil {
+					t.Fatalf("%v: %v", encoding, e)
+				}
+				answer = &pdns.Answer{TimeSec: uint64(decoded.Time.Unix()), TimeNsec: uint32(decoded.Time.Nanosecond()), Sensor: decoded.Sensor, Name: decoded.Name, Ttl: decoded.Ttl, Class: decoded.Class[1].(string), Type: decoded.Type[1].(string), Data: decoded.Data}
+			}
+
+			expected[i].TimeSec, expected[i].TimeNsec, expected[i].Sensor = uint64(seen.Unix()), uint32(seen.Nanosecond()), "resolver1"
+			if answer.TimeSec != expected[i].TimeSec || answer.TimeNsec != expected[i].TimeNsec || answer.Sensor != expected[i].Sensor || answer.Name != expected[i].Name || answer.Ttl != expected[i].Ttl || answer.Class != expected[i].Class || answer.Type != expected[i].Type || answer.Data != expected[i].Data {
+				t.Errorf("%v: got %v, expected %v", encoding, answer, expected[i])
+			}
+			if string(message.Key) != expected[i].Name {
+				t.Errorf("%v: got key %q, expected %q", encoding, message.Key, expected[i].Name)
+			}
+		}
+	}
+}
+
+func TestKafkaCounters(t *testing.T) {
+	tests := []struct {
+		name              string
+		e, rejected       error
+		delivered, failed uint64
+	}{
+		{"delivered", nil, nil, 4, 0},
+		// reported by the completion
+		{"failed", errors.New("broker unavailable"), nil, 0, 4},
+		// returned by WriteMessages
+		{"rejected", nil, errors.New("writer closed"), 0, 4},
+	}
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		output, producer, _ := kafkaTest(ENCODINGJSON)
+		producer.e, producer.rejected = test.e, test.rejected
+
+		// the output is shared by the handlers of the workers
+		handlers := []dnstapserver.DnstapMessageHandler{NewResolverResponseOutputMessageHandler(output), NewResolverResponseOutputMessageHandler(output)}
+		for _, handler := range handlers {
+			handler.Handle(testMessage(t, seen, "www.example.com. 300 IN A 192.0.2.1", "www.example.com. 300 IN A 192.0.2.2"))
+		}
+		handlers[0].Close()
+		if producer.closed {
+			t.Errorf("%v: producer closed while still used", test.name)
+		}
+		handlers[1].Close()
+		if !producer.closed {
+			t.Errorf("%v: producer not closed", test.name)
+		}
+
+		if output.delivered != test.delivered || output.failed != test.failed {
+			t.Errorf("%v: got %v delivered and %v failed, expected %v and %v", test.name, output.delivered, output.failed, test.delivered, test.failed)
+		}
+	}
+}
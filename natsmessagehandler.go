@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+	"github.com/nats-io/nats.go"
+)
+
+// characters not allowed in subject tokens
+var subjectEscaper = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_", "\r", "_", "\n", "_")
+
+// natsOutput publishes a message per answer on a subject derived from the
+// answer, e.g. "pdns.a.com" for "pdns.{type}.{tld}", shared by the message
+// handlers of all workers. The client reconnects on its own and buffers the
+// messages published while disconnected, messages that do not fit in the
+// buffer are dropped (and counted).
+type natsOutput struct {
+	connection *nats.Conn
+	subject    string
+	encoding   string
+
+	published uint64
+	dropped   uint64
+
+	mutex      *sync.Mutex
+	references int
+}
+
+// subject token for a value, lower case
+func token(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return subjectEscaper.Replace(strings.ToLower(value))
+}
+
+// the subject of the answer, the template placeholders are {type}, {class},
+// {tld} and {sensor}
+func (this *natsOutput) subjectOf(answer *Answer) string {
+	labels := dns.SplitDomainName(answer.Name)
+	tld := ""
+	if 0 < len(labels) {
+		tld = labels[len(labels)-1]
+	}
+
+	return strings.NewReplacer(
+		"{type}", token(dns.TypeToString[answer.Type]),
+		"{class}", token(dns.ClassToString[answer.Class]),
+		"{tld}", token(tld),
+		"{sensor}", token(answer.Sensor)).Replace(this.subject)
+}
+
+func (this *natsOutput) write(answers []Answer) {
+	for i := range answers {
+		if data, ok := encodeAnswer(&answers[i], this.encoding); ok {
+			if e := this.connection.Publish(this.subjectOf(&answers[i]), data); e == nil {
+				atomic.AddUint64(&this.published, 1)
+			} else if atomic.AddUint64(&this.dropped, 1) == 1 {
+				// the first failure, the counts are reported when closed
+				fmt.Fprintf(os.Stderr, "Failed to publish to NATS: %v\n", e)
+			}
+		}
+	}
+}
+
+func (this *natsOutput) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *natsOutput) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		if this.connection.IsConnected() {
+			if e := this.connection.FlushTimeout(RELAYTIMEOUT); e != nil {
+				fmt.Fprintf(os.Stderr, "Failed to flush NATS connection: %v\n", e)
+			}
+		} else if buffered, e := this.connection.Buffered(); e == nil && 0 < buffered {
+			fmt.Fprintf(os.Stderr, "NATS closed while disconnected, %v buffered byte(s) discarded\n", buffered)
+		}
+		this.connection.Close()
+
+		fmt.Fprintf(os.Stderr, "NATS published %v answer(s), %v dropped\n", atomic.LoadUint64(&this.published), atomic.LoadUint64(&this.dropped))
+	}
+}
+
+// NewNatsOutput creates the output shared by the NATS message handlers of all
+// workers, url is e.g. "nats://localhost:4222" and buffer the size of the
+// buffer (bytes) used while (re)connecting.
+func NewNatsOutput(url string, subject string, encoding string, buffer int) *natsOutput {
+	fmt.Fprintf(os.Stderr, "Creating NATS output \"%v\" (%v)\n", subject, url)
+
+	connection, e := nats.Connect(url,
+		nats.Name("passivedns"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectBufSize(buffer),
+		nats.DisconnectErrHandler(func(connection *nats.Conn, e error) {
+			if e != nil {
+				fmt.Fprintf(os.Stderr, "Disconnected from NATS: %v\n", e)
+			}
+		}),
+		nats.ReconnectHandler(func(connection *nats.Conn) {
+			fmt.Fprintf(os.Stderr, "Connected to NATS \"%v\"\n", connection.ConnectedUrl())
+		}))
+	if e != nil {
+		fatalln(e)
+	}
+
+	return &natsOutput{connection: connection, subject: subject, encoding: encoding, mutex: new(sync.Mutex)}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	dnstap "passivedns/dnstap"
+	dnstapserver "passivedns/dnstapserver"
+
+	"github.com/miekg/dns"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+func TestNatsSubject(t *testing.T) {
+	tests := []struct {
+		subject  string
+		answer   Answer
+		expected string
+	}{
+		{"pdns.{type}.{tld}", Answer{Name: "www.Example.COM.", Class: dns.ClassINET, Type: dns.TypeA}, "pdns.a.com"},
+		{"pdns.{class}.{type}", Answer{Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeAAAA}, "pdns.in.aaaa"},
+		// no sensor, escaped sensor
+		{"pdns.{sensor}.{type}", Answer{Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeCNAME}, "pdns._.cname"},
+		{"pdns.{sensor}.{type}", Answer{Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Sensor: "ns1.example.net *>"}, "pdns.ns1_example_net___.a"},
+		// the root has no top level domain
+		{"pdns.{tld}", Answer{Name: ".", Class: dns.ClassINET, Type: dns.TypeNS}, "pdns._"},
+		// no placeholders
+		{"pdns", Answer{Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeA}, "pdns"},
+	}
+
+	for _, test := range tests {
+		output := &natsOutput{subject: test.subject}
+		if subject := output.subjectOf(&test.answer); subject != test.expected {
+			t.Errorf("%v for %v %v: got %v, expected %v", test.subject, test.answer.Name, dns.TypeToString[test.answer.Type], subject, test.expected)
+		}
+	}
+}
+
+// a NATS server on a port of its own, restarted on the same port
+func natsServer(port int) *server.Server {
+	options := test.DefaultTestOptions
+	options.Port = port
+	return test.RunServer(&options)
+}
+
+// the messages received on the subject until the count or the timeout
+func natsReceive(t *testing.T, url string, subject string, count int, publish func()) []*nats.Msg {
+	connection, e := nats.Connect(url)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer connection.Close()
+
+	received := make(chan *nats.Msg, 2*count)
+	if _, e := connection.ChanSubscribe(subject, received); e != nil {
+		t.Fatal(e)
+	}
+	if e := connection.Flush(); e != nil {
+		t.Fatal(e)
+	}
+	publish()
+
+	messages := []*nats.Msg{}
+	timeout := time.After(10 * time.Second)
+	for len(messages) < count {
+		select {
+		case message := <-received:
+			messages = append(messages, message)
+		case <-timeout:
+			return messages
+		}
+	}
+	return messages
+}
+
+func TestNatsPublish(t *testing.T) {
+	running := natsServer(-1)
+	defer running.Shutdown()
+
+	output := NewNatsOutput(running.ClientURL(), "pdns.{sensor}.{type}", ENCODINGJSON, 1024*1024)
+	handler := NewResolverResponseOutputMessageHandler(output)
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	messages := natsReceive(t, running.ClientURL(), "pdns.>", 2, func() {
+		handler.(dnstapserver.DnstapEnvelopeHandler).HandleEnvelope(&dnstap.Dnstap{
+			Identity: []byte("resolver1"),
+			Message:  testMessage(t, seen, "www.example.com. 300 IN CNAME web.example.com.", "web.example.com. 60 IN A 192.0.2.1")})
+	})
+	handler.Close()
+
+	subjects := []string{}
+	for _, message := range messages {
+		subjects = append(subjects, message.Subject)
+	}
+	if fmt.Sprint(subjects) != "[pdns.resolver1.cname pdns.resolver1.a]" {
+		t.Errorf("got subjects %v", subjects)
+	}
+	if output.published != 2 || output.dropped != 0 {
+		t.Errorf("got %v published and %v dropped, expected 2 and 0", output.published, output.dropped)
+	}
+}
+
+// messages published while disconnected are buffered and sent once
+// reconnected, those that do not fit in the buffer are dropped
+func TestNatsReconnect(t *testing.T) {
+	running := natsServer(-1)
+	url, port := running.ClientURL(), running.Addr().(*net.TCPAddr).Port
+	defer func() { running.Shutdown() }()
+
+	const buffer = 4096
+	output := NewNatsOutput(url, "pdns.{type}", ENCODINGJSON, buffer)
+	handler := NewResolverResponseOutputMessageHandler(output)
+	defer handler.Close()
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	running.Shutdown()
+	for i := 0; output.connection.IsConnected(); i++ {
+		if 100 < i {
+			t.Fatal("still connected to the stopped server")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// some fit in the buffer, the rest are dropped
+	const count = 100
+	for i := 0; i < count; i++ {
+		handler.Handle(testMessage(t, seen, fmt.Sprintf("h%d.example.com. 300 IN A 192.0.2.%d", i, i)))
+	}
+	buffered, dropped := int(output.published), int(output.dropped)
+	if buffered == 0 || dropped == 0 || buffered+dropped != count {
+		t.Fatalf("got %v buffered and %v dropped, expected both of %v", buffered, dropped, count)
+	}
+
+	running = natsServer(port)
+	messages := natsReceive(t, url, "pdns.a", buffered, func() {})
+	if len(messages) != buffered {
+		t.Errorf("got %v message(s) after reconnecting, expected the %v buffered", len(messages), buffered)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeOpensearch answers the bulk requests with the responses in order, an
+// int is the status of the whole request, a slice the status of each action
+type fakeOpensearch struct {
+	responses []interface{}
+
+	mutex     sync.Mutex
+	templates map[string]map[string]interface{}
+	requests  [][]map[string]interface{}
+}
+
+func (this *fakeOpensearch) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	body, _ := ioutil.ReadAll(request.Body)
+	switch {
+	case request.Method == http.MethodPut && strings.HasPrefix(request.URL.Path, "/_index_template/"):
+		template := map[string]interface{}{}
+		if e := json.Unmarshal(body, &template); e != nil {
+			http.Error(writer, e.Error(), http.StatusBadRequest)
+			return
+		}
+		this.templates[strings.TrimPrefix(request.URL.Path, "/_index_template/")] = template
+		fmt.Fprint(writer, `{"acknowledged": true}`)
+	case request.Method == http.MethodPost && request.URL.Path == "/_bulk":
+		if request.Header.Get("Content-Type") != "application/x-ndjson" {
+			http.Error(writer, "not ndjson", http.StatusNotAcceptable)
+			return
+		}
+		lines := []map[string]interface{}{}
+		for scanner := bufio.NewScanner(bytes.NewReader(body)); scanner.Scan(); {
+			line := map[string]interface{}{}
+			if e := json.Unmarshal(scanner.Bytes(), &line); e != nil {
+				http.Error(writer, e.Error(), http.StatusBadRequest)
+				return
+			}
+			lines = append(lines, line)
+		}
+		this.requests = append(this.requests, lines)
+
+		var response interface{} = []int{}
+		if 0 < len(this.responses) {
+			response, this.responses = this.responses[0], this.responses[1:]
+		}
+		switch response := response.(type) {
+		case int:
+			http.Error(writer, http.StatusText(response), response)
+		case []int:
+			items, errors := []interface{}{}, false
+			for i := 0; i < len(lines)/2; i++ {
+				status := http.StatusOK
+				if i < len(response) {
+					status = response[i]
+				}
+				item := map[string]interface{}{"status": status}
+				if 300 <= status {
+					errors = true
+					item["error"] = map[string]interface{}{"type": http.StatusText(status)}
+				}
+				items = append(items, map[string]interface{}{"update": item})
+			}
+			json.NewEncoder(writer).Encode(map[string]interface{}{"errors": errors, "items": items})
+		}
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+func opensearchTest(t *testing.T, responses ...interface{}) (*fakeOpensearch, *opensearchWriter) {
+	fake := &fakeOpensearch{responses: responses, templates: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	writer := NewOpensearchWriter(server.URL, "pdns-{2006.01.02}", 1000, time.Minute)
+	t.Cleanup(func() {
+		writer.acquire()
+		writer.release()
+	})
+	backend := writer.backend.(*opensearchWriter)
+	backend.backoff = time.Millisecond
+	return fake, backend
+}
+
+func opensearchAnswer(seen time.Time, name string, rrtype uint16, data string) Answer {
+	return Answer{Time: seen, Name: name, Class: dns.ClassINET, Type: rrtype, Data: data}
+}
+
+func TestOpensearchTemplate(t *testing.T) {
+	fake, _ := opensearchTest(t)
+
+	template, ok := fake.templates["pdns"]
+	if !ok {
+		t.Fatalf("got templates %v, expected pdns", fake.templates)
+	}
+	if patterns := fmt.Sprint(template["index_patterns"]); patterns != "[pdns-*]" {
+		t.Errorf("got index patterns %v", patterns)
+	}
+	mappings := template["template"].(map[string]interface{})["mappings"].(map[string]interface{})["properties"].(map[string]interface{})
+	for field, kind := range map[string]string{"name": "keyword", "rname": "keyword", "data": "keyword", "address": "ip", "count": "long", "first_seen": "date", "last_seen": "date"} {
+		if mapping, ok := mappings[field].(map[string]interface{}); !ok || mapping["type"] != kind {
+			t.Errorf("got mapping %v for %v, expected %v", mappings[field], field, kind)
+		}
+	}
+}
+
+func TestOpensearchUpsert(t *testing.T) {
+	fake, writer := opensearchTest(t)
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	answers := []Answer{
+		opensearchAnswer(seen.Add(time.Minute), "www.example.com.", dns.TypeA, "192.0.2.1"),
+		// a case variant (0x20) of the same record
+		opensearchAnswer(seen, "WwW.Example.COM.", dns.TypeA, "192.0.2.1"),
+		opensearchAnswer(seen, "Web.example.com.", dns.TypeCNAME, "WWW.example.com."),
+		// the next day, another index
+		opensearchAnswer(seen.Add(24*time.Hour), "www.example.com.", dns.TypeA, "192.0.2.1")}
+	if e := writer.insert(answers); e != nil {
+		t.Fatal(e)
+	}
+
+	if len(fake.requests) != 1 || len(fake.requests[0]) != 2*3 {
+		t.Fatalf("got %v bulk request(s), expected one of 3 actions", len(fake.requests))
+	}
+	documents, ids := map[string]map[string]interface{}{}, map[string]interface{}{}
+	for i := 0; i < len(fake.requests[0]); i += 2 {
+		action := fake.requests[0][i]["update"].(map[string]interface{})
+		update := fake.requests[0][i+1]
+		script := update["script"].(map[string]interface{})
+		if script["source"] != OPENSEARCHSCRIPT || script["lang"] != "painless" {
+			t.Errorf("got script %v", script)
+		}
+
+		upsert := update["upsert"].(map[string]interface{})
+		params := script["params"].(map[string]interface{})
+		for _, field := range []string{"count", "first_seen", "last_seen"} {
+			if params[field] != upsert[field] {
+				t.Errorf("got %v %v in the script, %v in the upsert", field, params[field], upsert[field])
+			}
+		}
+		key := fmt.Sprintf("%v %v %v", action["_index"], upsert["name"], upsert["type"])
+		documents[key], ids[key] = upsert, action["_id"]
+
+		// a SHA-1 of the record
+		if len(action["_id"].(string)) != 40 {
+			t.Errorf("got ID %v", action["_id"])
+		}
+	}
+
+	millis := func(seen time.Time) float64 { return float64(seen.UnixNano() / int64(time.Millisecond)) }
+	tests := []struct {
+		key         string
+		count       float64
+		first, last time.Time
+		address     interface{}
+	}{
+		{"pdns-2021.06.01 www.example.com A", 2, seen, seen.Add(time.Minute), "192.0.2.1"},
+		{"pdns-2021.06.01 web.example.com CNAME", 1, seen, seen, nil},
+		{"pdns-2021.06.02 www.example.com A", 1, seen.Add(24 * time.Hour), seen.Add(24 * time.Hour), "192.0.2.1"},
+	}
+	for _, test := range tests {
+		document, ok := documents[test.key]
+		if !ok {
+			t.Errorf("no document %v in %v", test.key, documents)
+			continue
+		}
+		if document["count"] != test.count || document["first_seen"] != millis(test.first) || document["last_seen"] != millis(test.last) || document["address"] != test.address {
+			t.Errorf("%v: got %v", test.key, document)
+		}
+	}
+	if document := documents["pdns-2021.06.01 www.example.com A"]; document["rname"] != "com.example.www" {
+		t.Errorf("got rname %v", document["rname"])
+	}
+	if document := documents["pdns-2021.06.01 web.example.com CNAME"]; document["data"] != "www.example.com" {
+		t.Errorf("got data %v", document["data"])
+	}
+
+	// the same record in another batch updates the same document
+	if e := writer.insert([]Answer{opensearchAnswer(seen, "WWW.EXAMPLE.COM.", dns.TypeA, "192.0.2.1")}); e != nil {
+		t.Fatal(e)
+	}
+	if id, expected := fake.requests[1][0]["update"].(map[string]interface{})["_id"], ids["pdns-2021.06.01 www.example.com A"]; id != expected {
+		t.Errorf("got ID %v, expected %v", id, expected)
+	}
+}
+
+// rejected requests and actions are retried, other failures are not
+func TestOpensearchRetry(t *testing.T) {
+	fake, writer := opensearchTest(t,
+		http.StatusTooManyRequests,
+		http.StatusServiceUnavailable,
+		[]int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusConflict, http.StatusOK, http.StatusBadRequest},
+		[]int{http.StatusConflict})
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	answers := []Answer{}
+	for i := 0; i < 5; i++ {
+		answers = append(answers, opensearchAnswer(seen, fmt.Sprintf("h%d.example.com.", i), dns.TypeA, fmt.Sprintf("192.0.2.%d", i)))
+	}
+	if e := writer.insert(answers); e != nil {
+		t.Fatal(e)
+	}
+
+	// all, all, all, the three rejected, the one still in conflict
+	expected := []int{5, 5, 5, 3, 1}
+	if len(fake.requests) != len(expected) {
+		t.Fatalf("got %v bulk request(s), expected %v", len(fake.requests), len(expected))
+	}
+	for i, request := range fake.requests {
+		if len(request) != 2*expected[i] {
+			t.Errorf("bulk request %v: got %v action(s), expected %v", i, len(request)/2, expected[i])
+		}
+	}
+	if writer.failed != 1 {
+		t.Errorf("got %v failed document(s), expected 1", writer.failed)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetAnswer is the schema of the Parquet files, a row per answer
+type parquetAnswer struct {
+	Time            int64   `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MICROS"`
+	Sensor          string  `parquet:"name=sensor, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Name            string  `parquet:"name=rrname, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Class           string  `parquet:"name=rrclass, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Type            string  `parquet:"name=rrtype, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Data            string  `parquet:"name=rdata, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Ttl             int64   `parquet:"name=ttl, type=INT64"`
+	QueryAddress    *string `parquet:"name=query_address, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ResponseAddress *string `parquet:"name=response_address, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+func parquetRow(answer Answer) *parquetAnswer {
+	row := &parquetAnswer{
+		Time:   answer.Time.UnixNano() / int64(time.Microsecond),
+		Sensor: answer.Sensor,
+		Name:   strings.TrimRight(answer.Name, "."),
+		Class:  dns.ClassToString[answer.Class],
+		Type:   dns.TypeToString[answer.Type],
+		Data:   strings.TrimRight(answer.Data, "."),
+		Ttl:    int64(answer.Ttl)}
+	if answer.QueryAddress != nil {
+		address := answer.QueryAddress.String()
+		row.QueryAddress = &address
+	}
+	if answer.ResponseAddress != nil {
+		address := answer.ResponseAddress.String()
+		row.ResponseAddress = &address
+	}
+	return row
+}
+
+// parquet compression codec by (case insensitive) name
+func parquetCompression(name string) (parquet.CompressionCodec, error) {
+	if strings.EqualFold(name, "none") {
+		return parquet.CompressionCodec_UNCOMPRESSED, nil
+	}
+	switch codec, e := parquet.CompressionCodecFromString(strings.ToUpper(name)); codec {
+	case parquet.CompressionCodec_SNAPPY, parquet.CompressionCodec_GZIP, parquet.CompressionCodec_ZSTD:
+		return codec, nil
+	default:
+		if e == nil {
+			e = fmt.Errorf("unsupported Parquet compression \"%v\", expected snappy, gzip, zstd or none", name)
+		}
+		return codec, e
+	}
+}
+
+// parquetFile is a Parquet file being written, it is written to a temporary
+// file which is renamed once complete (readers need the footer)
+type parquetFile struct {
+	path   string
+	file   *os.File
+	writer *writer.ParquetWriter
+	rows   int
+}
+
+// write the answers, buffered until the row group is full (see group)
+func (this *parquetFile) write(answers []Answer) error {
+	for _, answer := range answers {
+		if e := this.writer.Write(parquetRow(answer)); e != nil {
+			return e
+		}
+	}
+	this.rows += len(answers)
+	return nil
+}
+
+// write the buffered answers as a row group
+func (this *parquetFile) group() error {
+	return this.writer.Flush(true)
+}
+
+// give up a file which failed to be written, the temporary file (without a
+// footer, so unreadable) is kept as e.g. "pdns.parquet.failed"
+func (this *parquetFile) abort() string {
+	this.file.Close()
+	failed := this.path + ".failed"
+	if e := os.Rename(this.file.Name(), failed); e != nil {
+		return this.file.Name()
+	}
+	return failed
+}
+
+func (this *parquetFile) close() error {
+	if e := this.writer.WriteStop(); e != nil {
+		this.file.Close()
+		return e
+	}
+	if e := this.file.Close(); e != nil {
+		return e
+	}
+	return os.Rename(this.file.Name(), this.path)
+}
+
+func parquetCreate(path string, compression parquet.CompressionCodec) (*parquetFile, error) {
+	file, e := os.OpenFile(path+".tmp", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if e != nil {
+		return nil, e
+	}
+
+	writer, e := writer.NewParquetWriterFromWriter(file, new(parquetAnswer), 1)
+	if e != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, e
+	}
+	writer.CompressionType = compression
+
+	return &parquetFile{path: path, file: file, writer: writer}, nil
+}
+
+// parquetWriter writes all answers to Parquet files, rotated hourly. The files
+// are named after the time they were opened, e.g.
+// "pdns-20261016T170000.000000.parquet" for "pdns.parquet". Each batch is a
+// row group, a batch is written completely or not at all: a file which
+// failed to be written is given up (see abort) and the batch is retried in a
+// new file.
+type parquetWriter struct {
+	path        string
+	compression parquet.CompressionCodec
+
+	current *parquetFile
+	opened  time.Time
+}
+
+// rotate at the top of the hour, also while idle
+func (this *parquetWriter) tick() {
+	if this.current != nil && !time.Now().Truncate(time.Hour).Equal(this.opened.Truncate(time.Hour)) {
+		this.close()
+	}
+}
+
+func (this *parquetWriter) insert(answers []Answer) error {
+	this.tick()
+	now := time.Now()
+
+	if this.current == nil {
+		path := rotatedPath(this.path, now)
+		if current, e := parquetCreate(path, this.compression); e == nil {
+			this.current, this.opened = current, now
+		} else {
+			return e
+		}
+	}
+
+	e := this.current.write(answers)
+	if e == nil {
+		e = this.current.group()
+	}
+	if e != nil {
+		// the answers of the batch written so far cannot be taken back, do not
+		// retry into (and duplicate them in) the same file
+		failed := this.current.abort()
+		fmt.Fprintf(os.Stderr, "Failed to write Parquet file \"%v\", %v answer(s) left in \"%v\": %v\n", this.current.path, this.current.rows, failed, e)
+		this.current = nil
+		return e
+	}
+	return nil
+}
+
+func (this *parquetWriter) close() {
+	if this.current != nil {
+		if e := this.current.close(); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to finish Parquet file \"%v\": %v\n", this.current.path, e)
+		}
+		this.current = nil
+	}
+}
+
+// NewParquetWriter creates the writer shared by the Parquet message handlers
+// of all workers.
+func NewParquetWriter(path string, compression string, size int, age time.Duration) *answerWriter {
+	fmt.Fprintf(os.Stderr, "Creating Parquet writer \"%v\"\n", path)
+
+	codec, e := parquetCompression(compression)
+	if e != nil {
+		fatalln(e)
+	}
+
+	return newAnswerWriter("Parquet", &parquetWriter{path: path, compression: codec}, []uint16{}, size, age)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func parquetAnswers(seen time.Time, first int, count int) []Answer {
+	answers := []Answer{}
+	for i := first; i < first+count; i++ {
+		answers = append(answers, Answer{Time: seen.Add(time.Duration(i) * time.Second), Sensor: "resolver1", Name: fmt.Sprintf("h%d.example.com.", i), Ttl: 300, Class: dns.ClassINET, Type: dns.TypeA, Data: fmt.Sprintf("192.0.2.%d", i), QueryAddress: net.ParseIP("198.51.100.1")})
+	}
+	return answers
+}
+
+// the rows of a Parquet file, as "time name type data" and the number of
+// row groups
+func parquetRead(t *testing.T, path string) ([]string, int) {
+	file, e := local.NewLocalFileReader(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer file.Close()
+
+	parquetReader, e := reader.NewParquetReader(file, new(parquetAnswer), 1)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer parquetReader.ReadStop()
+
+	rows := make([]parquetAnswer, parquetReader.GetNumRows())
+	if e := parquetReader.Read(&rows); e != nil {
+		t.Fatal(e)
+	}
+	found := []string{}
+	for _, row := range rows {
+		address := "-"
+		if row.QueryAddress != nil {
+			address = *row.QueryAddress
+		}
+		found = append(found, fmt.Sprintf("%v %v %v %v %v %v %v", time.Unix(0, row.Time*int64(time.Microsecond)).UTC().Format(time.RFC3339), row.Sensor, row.Name, row.Type, row.Data, row.Ttl, address))
+	}
+	return found, len(parquetReader.Footer.RowGroups)
+}
+
+func parquetExpected(answers []Answer) []string {
+	expected := []string{}
+	for _, answer := range answers {
+		expected = append(expected, fmt.Sprintf("%v %v %v A %v 300 198.51.100.1", answer.Time.UTC().Format(time.RFC3339), answer.Sensor, answer.Name[:len(answer.Name)-1], answer.Data))
+	}
+	return expected
+}
+
+func parquetFiles(t *testing.T, pattern string) []string {
+	files, e := filepath.Glob(pattern)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return files
+}
+
+// a row group per batch, read back once the file is finished
+func TestParquetWriter(t *testing.T) {
+	directory := t.TempDir()
+	writer := &parquetWriter{path: filepath.Join(directory, "pdns.parquet"), compression: parquet.CompressionCodec_SNAPPY}
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	batches := [][]Answer{parquetAnswers(seen, 0, 3), parquetAnswers(seen, 3, 2)}
+	for _, batch := range batches {
+		if e := writer.insert(batch); e != nil {
+			t.Fatal(e)
+		}
+	}
+	// being written
+	if files := parquetFiles(t, filepath.Join(directory, "pdns-*.parquet")); len(files) != 0 {
+		t.Errorf("got %v before the file is finished", files)
+	}
+
+	// an idle writer finishes the file at the top of the hour
+	writer.tick()
+	if writer.current == nil {
+		t.Fatal("file finished within the hour")
+	}
+	writer.opened = writer.opened.Add(-time.Hour)
+	writer.tick()
+
+	files := parquetFiles(t, filepath.Join(directory, "pdns-*.parquet"))
+	if len(files) != 1 {
+		t.Fatalf("got %v, expected a file", files)
+	}
+	rows, groups := parquetRead(t, files[0])
+	if expected := parquetExpected(append(batches[0], batches[1]...)); fmt.Sprint(rows) != fmt.Sprint(expected) {
+		t.Errorf("got %q, expected %q", rows, expected)
+	}
+	if groups != len(batches) {
+		t.Errorf("got %v row group(s), expected one per batch", groups)
+	}
+}
+
+// a batch which fails is retried in a new file, the answers are written once
+func TestParquetWriterFailure(t *testing.T) {
+	directory := t.TempDir()
+	writer := &parquetWriter{path: filepath.Join(directory, "pdns.parquet"), compression: parquet.CompressionCodec_SNAPPY}
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	if e := writer.insert(parquetAnswers(seen, 0, 3)); e != nil {
+		t.Fatal(e)
+	}
+
+	// e.g. the disk is full
+	writer.current.file.Close()
+	batch := parquetAnswers(seen, 3, 2)
+	if e := writer.insert(batch); e == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if failed := parquetFiles(t, filepath.Join(directory, "pdns-*.parquet.failed")); len(failed) != 1 {
+		t.Errorf("got %v, expected the failed file to be kept aside", failed)
+	}
+
+	// retried like answerWriter.flush
+	if e := writer.insert(batch); e != nil {
+		t.Fatal(e)
+	}
+	writer.close()
+
+	files := parquetFiles(t, filepath.Join(directory, "pdns-*.parquet"))
+	if len(files) != 1 {
+		t.Fatalf("got %v, expected a file", files)
+	}
+	if rows, _ := parquetRead(t, files[0]); fmt.Sprint(rows) != fmt.Sprint(parquetExpected(batch)) {
+		t.Errorf("got %q, expected the retried batch once", rows)
+	}
+}
+
+func TestExportSqlite(t *testing.T) {
+	directory := t.TempDir()
+	database := filepath.Join(directory, "pdns.db")
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	answers := parquetAnswers(seen, 0, 5)
+	writer := NewSqliteWriter(database, PARTITIONNONE, 1000, time.Minute)
+	if e := writer.backend.insert(answers); e != nil {
+		t.Fatal(e)
+	}
+	writer.acquire()
+	writer.release()
+
+	path := filepath.Join(directory, "pdns.parquet")
+	file, e := parquetCreate(path, parquet.CompressionCodec_ZSTD)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if count, e := exportSqlite(database, file); e != nil || count != len(answers) {
+		t.Fatalf("exported %v answer(s) (%v), expected %v", count, e, len(answers))
+	}
+	if e := file.close(); e != nil {
+		t.Fatal(e)
+	}
+
+	// the sensor and the addresses are not stored in SQLite
+	expected := []string{}
+	for _, answer := range answers {
+		expected = append(expected, fmt.Sprintf("%v  %v A %v 300 -", answer.Time.UTC().Format(time.RFC3339), answer.Name[:len(answer.Name)-1], answer.Data))
+	}
+	if rows, _ := parquetRead(t, path); fmt.Sprint(rows) != fmt.Sprint(expected) {
+		t.Errorf("got %q, expected %q", rows, expected)
+	}
+}
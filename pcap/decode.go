@@ -0,0 +1,486 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	dnstap "passivedns/dnstap"
+
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	protocolTCP uint8 = 6
+	protocolUDP uint8 = 17
+)
+
+const (
+	// IP fragments not reassembled within this time (packet time) are dropped
+	FRAGMENTTIMEOUT = 30 * time.Second
+	// TCP streams without any segments within this time (packet time) are dropped
+	STREAMTIMEOUT = 2 * time.Minute
+	// upper limits on the reassembly state, protects against memory exhaustion on odd captures
+	MAXFRAGMENTS  = 4096
+	MAXSTREAMS    = 65536
+	MAXSEGMENTS   = 64
+	MAXSTREAMSIZE = 256 * 1024
+)
+
+type fragmentKey struct {
+	source, destination string
+	id                  uint32
+	protocol            uint8
+}
+
+type fragment struct {
+	seen   time.Time
+	total  int
+	pieces map[int][]byte
+}
+
+// assemble the datagram when all pieces are present
+func (this *fragment) assemble() ([]byte, bool) {
+	if this.total < 0 {
+		return nil, false
+	}
+
+	offsets := make([]int, 0, len(this.pieces))
+	for offset := range this.pieces {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	datagram := make([]byte, this.total)
+	end := 0
+	for _, offset := range offsets {
+		if end < offset {
+			// hole, more pieces to come
+			return nil, false
+		}
+		if this.total <= offset {
+			break
+		}
+		if last := offset + copy(datagram[offset:], this.pieces[offset]); end < last {
+			end = last
+		}
+	}
+
+	return datagram, this.total <= end
+}
+
+type streamKey struct {
+	source, destination string
+	sport, dport        uint16
+}
+
+type stream struct {
+	seen    time.Time
+	synced  bool
+	next    uint32
+	buffer  []byte
+	pending map[uint32][]byte
+}
+
+// append a segment (in sequence order) to the stream buffer
+func (this *stream) append(seq uint32, payload []byte) bool {
+	if overlap := int32(this.next - seq); 0 < overlap {
+		// retransmission or partly overlapping segment
+		if len(payload) <= int(overlap) {
+			return false
+		}
+		payload = payload[overlap:]
+	} else if overlap < 0 {
+		return false
+	}
+
+	this.buffer = append(this.buffer, payload...)
+	this.next += uint32(len(payload))
+	return true
+}
+
+func (this *stream) segment(seq uint32, payload []byte) bool {
+	if 0 < int32(seq-this.next) {
+		// out of order, keep it until the gap is filled
+		if MAXSEGMENTS <= len(this.pending) {
+			return false
+		}
+		this.pending[seq] = append([]byte(nil), payload...)
+		return true
+	}
+
+	this.append(seq, payload)
+	for drained := true; drained && 0 < len(this.pending); {
+		drained = false
+		for seq, payload := range this.pending {
+			if int32(seq-this.next) <= 0 {
+				delete(this.pending, seq)
+				this.append(seq, payload)
+				drained = true
+			}
+		}
+	}
+
+	return len(this.buffer) <= MAXSTREAMSIZE
+}
+
+// Decoder extracts DNS responses from captured packets, reassembling
+// fragmented IP datagrams and TCP streams, and converts them into dnstap
+// messages. A Decoder is not safe for concurrent use.
+type Decoder struct {
+	// Port is the DNS server port, only responses sent from this port are decoded
+	Port uint16
+	// Type of the produced dnstap messages, zero means RESOLVER_RESPONSE or
+	// CLIENT_RESPONSE depending on the RD (recursion desired) bit
+	Type dnstap.Message_Type
+
+	fragments map[fragmentKey]*fragment
+	streams   map[streamKey]*stream
+	expired   time.Time
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{Port: 53, fragments: make(map[fragmentKey]*fragment), streams: make(map[streamKey]*stream)}
+}
+
+// Decode a single packet, returning the dnstap messages for all DNS
+// responses completed by the packet.
+func (this *Decoder) Decode(packet Packet) []*dnstap.Message {
+	this.expire(packet.Time)
+
+	if data, ok := link(packet.LinkType, packet.Data); ok && 0 < len(data) {
+		switch data[0] >> 4 {
+		case 4:
+			return this.ipv4(packet.Time, data)
+		case 6:
+			return this.ipv6(packet.Time, data)
+		}
+	}
+	return nil
+}
+
+// drop incomplete fragments and idle streams
+func (this *Decoder) expire(now time.Time) {
+	if now.Sub(this.expired) < time.Second {
+		return
+	}
+	this.expired = now
+
+	for key, fragment := range this.fragments {
+		if FRAGMENTTIMEOUT < now.Sub(fragment.seen) {
+			delete(this.fragments, key)
+		}
+	}
+	for key, stream := range this.streams {
+		if STREAMTIMEOUT < now.Sub(stream.seen) {
+			delete(this.streams, key)
+		}
+	}
+}
+
+// strip the link layer header, returning the IP datagram
+func link(linktype uint32, data []byte) ([]byte, bool) {
+	switch linktype {
+	case LINKTYPE_ETHERNET:
+		if len(data) < 14 {
+			return nil, false
+		}
+		ethertype, offset := binary.BigEndian.Uint16(data[12:14]), 14
+		// skip 802.1Q and 802.1ad (QinQ) VLAN tags
+		for (ethertype == 0x8100 || ethertype == 0x88a8 || ethertype == 0x9100) && offset+4 <= len(data) {
+			ethertype = binary.BigEndian.Uint16(data[offset+2 : offset+4])
+			offset += 4
+		}
+		return data[offset:], ethertype == 0x0800 || ethertype == 0x86dd
+
+	case LINKTYPE_NULL, LINKTYPE_LOOP:
+		// 4 byte address family, in host byte order for NULL and network byte order for LOOP
+		if len(data) < 4 {
+			return nil, false
+		}
+		family := binary.LittleEndian.Uint32(data[0:4])
+		if linktype == LINKTYPE_LOOP || 0xffff < family {
+			family = binary.BigEndian.Uint32(data[0:4])
+		}
+		// AF_INET, and AF_INET6 on the BSDs, Darwin and Linux respectively
+		return data[4:], family == 2 || family == 10 || family == 24 || family == 28 || family == 30
+
+	case LINKTYPE_LINUX_SLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		protocol := binary.BigEndian.Uint16(data[14:16])
+		return data[16:], protocol == 0x0800 || protocol == 0x86dd
+
+	case LINKTYPE_LINUX_SLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		protocol := binary.BigEndian.Uint16(data[0:2])
+		return data[20:], protocol == 0x0800 || protocol == 0x86dd
+
+	case LINKTYPE_RAW, LINKTYPE_RAW_BSD, LINKTYPE_RAW_OBSD, LINKTYPE_IPV4, LINKTYPE_IPV6:
+		return data, true
+
+	default:
+		return nil, false
+	}
+}
+
+func (this *Decoder) ipv4(now time.Time, data []byte) []*dnstap.Message {
+	if len(data) < 20 {
+		return nil
+	}
+
+	length := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < 20 || total < length || len(data) < total {
+		return nil
+	}
+	// strip link layer padding
+	data = data[:total]
+
+	protocol := data[9]
+	source, destination := net.IP(data[12:16]), net.IP(data[16:20])
+	flags := binary.BigEndian.Uint16(data[6:8])
+	payload := data[length:]
+
+	if more, offset := flags&0x2000 != 0, int(flags&0x1fff)*8; more || 0 < offset {
+		key := fragmentKey{source: string(source), destination: string(destination), id: uint32(binary.BigEndian.Uint16(data[4:6])), protocol: protocol}
+		var ok bool
+		if payload, ok = this.reassemble(now, key, offset, more, payload); !ok {
+			return nil
+		}
+	}
+
+	return this.transport(now, dnstap.SocketFamily_INET, protocol, source, destination, payload)
+}
+
+func (this *Decoder) ipv6(now time.Time, data []byte) []*dnstap.Message {
+	if len(data) < 40 {
+		return nil
+	}
+
+	total := 40 + int(binary.BigEndian.Uint16(data[4:6]))
+	if len(data) < total {
+		return nil
+	}
+	data = data[:total]
+
+	next, offset := data[6], 40
+	source, destination := net.IP(data[8:24]), net.IP(data[24:40])
+
+	for {
+		switch next {
+		case 0, 43, 60:
+			// hop-by-hop, routing and destination options
+			if len(data) < offset+8 {
+				return nil
+			}
+			next, offset = data[offset], offset+(int(data[offset+1])+1)*8
+
+		case 51:
+			// authentication header
+			if len(data) < offset+8 {
+				return nil
+			}
+			next, offset = data[offset], offset+(int(data[offset+1])+2)*4
+
+		case 44:
+			// fragment header
+			if len(data) < offset+8 {
+				return nil
+			}
+			flags := binary.BigEndian.Uint16(data[offset+2 : offset+4])
+			key := fragmentKey{source: string(source), destination: string(destination), id: binary.BigEndian.Uint32(data[offset+4 : offset+8]), protocol: data[offset]}
+			if payload, ok := this.reassemble(now, key, int(flags&^0x7), flags&0x1 != 0, data[offset+8:]); ok {
+				return this.transport(now, dnstap.SocketFamily_INET6, key.protocol, source, destination, payload)
+			}
+			return nil
+
+		default:
+			if len(data) < offset {
+				return nil
+			}
+			return this.transport(now, dnstap.SocketFamily_INET6, next, source, destination, data[offset:])
+		}
+	}
+}
+
+func (this *Decoder) reassemble(now time.Time, key fragmentKey, offset int, more bool, payload []byte) ([]byte, bool) {
+	// only DNS transports are worth the effort
+	if key.protocol != protocolUDP && key.protocol != protocolTCP {
+		return nil, false
+	}
+
+	current, ok := this.fragments[key]
+	if !ok {
+		if MAXFRAGMENTS <= len(this.fragments) {
+			return nil, false
+		}
+		current = &fragment{total: -1, pieces: make(map[int][]byte)}
+		this.fragments[key] = current
+	}
+
+	current.seen = now
+	current.pieces[offset] = append([]byte(nil), payload...)
+	if !more {
+		current.total = offset + len(payload)
+	}
+
+	if datagram, ok := current.assemble(); ok {
+		delete(this.fragments, key)
+		return datagram, true
+	}
+	return nil, false
+}
+
+func (this *Decoder) transport(now time.Time, family dnstap.SocketFamily, protocol uint8, source, destination net.IP, payload []byte) []*dnstap.Message {
+	switch protocol {
+	case protocolUDP:
+		if len(payload) < 8 {
+			return nil
+		}
+		sport, dport := binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4])
+		if length := int(binary.BigEndian.Uint16(payload[4:6])); 8 <= length && length <= len(payload) {
+			payload = payload[:length]
+		}
+		if sport == this.Port && response(payload[8:]) {
+			return []*dnstap.Message{this.message(now, family, dnstap.SocketProtocol_UDP, source, destination, sport, dport, payload[8:])}
+		}
+
+	case protocolTCP:
+		if len(payload) < 20 {
+			return nil
+		}
+		sport, dport := binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4])
+		if sport != this.Port {
+			return nil
+		}
+		offset := int(payload[12]>>4) * 4
+		if offset < 20 || len(payload) < offset {
+			return nil
+		}
+		return this.segment(now, family, source, destination, sport, dport, binary.BigEndian.Uint32(payload[4:8]), payload[13], payload[offset:])
+	}
+
+	return nil
+}
+
+func (this *Decoder) segment(now time.Time, family dnstap.SocketFamily, source, destination net.IP, sport, dport uint16, seq uint32, flags uint8, payload []byte) []*dnstap.Message {
+	const (
+		FIN = 0x01
+		SYN = 0x02
+		RST = 0x04
+	)
+
+	key := streamKey{source: string(source), destination: string(destination), sport: sport, dport: dport}
+	current, ok := this.streams[key]
+	if !ok || flags&SYN != 0 {
+		if len(payload) == 0 && flags&SYN == 0 {
+			// nothing to track (e.g. a lone ACK or FIN of an unknown stream)
+			return nil
+		}
+		if !ok && MAXSTREAMS <= len(this.streams) {
+			return nil
+		}
+		current = &stream{pending: make(map[uint32][]byte)}
+		this.streams[key] = current
+	}
+	current.seen = now
+
+	if flags&SYN != 0 {
+		current.synced, current.next = true, seq+1
+		seq++
+	} else if !current.synced {
+		// joined mid-stream, assume the segment starts with a message
+		current.synced, current.next = true, seq
+	}
+
+	messages := []*dnstap.Message{}
+	if 0 < len(payload) {
+		if !current.segment(seq, payload) {
+			delete(this.streams, key)
+			return nil
+		}
+
+		// DNS over TCP messages are prefixed by a two byte length
+		buffer := current.buffer
+		for 2 <= len(buffer) {
+			length := int(binary.BigEndian.Uint16(buffer[0:2]))
+			if len(buffer) < 2+length {
+				break
+			}
+			if response(buffer[2 : 2+length]) {
+				messages = append(messages, this.message(now, family, dnstap.SocketProtocol_TCP, source, destination, sport, dport, buffer[2:2+length]))
+			} else {
+				// out of sync (or not DNS at all), give up on the stream
+				delete(this.streams, key)
+				return messages
+			}
+			buffer = buffer[2+length:]
+		}
+		current.buffer = append(current.buffer[:0], buffer...)
+	}
+
+	if flags&(FIN|RST) != 0 {
+		delete(this.streams, key)
+	}
+
+	return messages
+}
+
+// response reports whether the payload looks like a DNS response to a standard query
+func response(payload []byte) bool {
+	return 12 <= len(payload) && payload[2]&0x80 != 0 && (payload[2]>>3)&0x0f == 0
+}
+
+func (this *Decoder) message(now time.Time, family dnstap.SocketFamily, protocol dnstap.SocketProtocol, source, destination net.IP, sport, dport uint16, payload []byte) *dnstap.Message {
+	kind := this.Type
+	if kind == 0 {
+		if payload[2]&0x01 != 0 {
+			// recursion desired, this is a response to a client (stub resolver or forwarder)
+			kind = dnstap.Message_CLIENT_RESPONSE
+		} else {
+			kind = dnstap.Message_RESOLVER_RESPONSE
+		}
+	}
+
+	// the querying side is the destination of the response
+	return &dnstap.Message{
+		Type:             kind.Enum(),
+		SocketFamily:     family.Enum(),
+		SocketProtocol:   protocol.Enum(),
+		QueryAddress:     append([]byte(nil), destination...),
+		QueryPort:        protobuf.Uint32(uint32(dport)),
+		ResponseAddress:  append([]byte(nil), source...),
+		ResponsePort:     protobuf.Uint32(uint32(sport)),
+		ResponseTimeSec:  protobuf.Uint64(uint64(now.Unix())),
+		ResponseTimeNsec: protobuf.Uint32(uint32(now.Nanosecond())),
+		ResponseMessage:  append([]byte(nil), payload...)}
+}
+
+// Read decodes every packet of the pcap or pcapng input and calls emit for
+// each DNS response found.
+func Read(input io.Reader, decoder *Decoder, emit func(message *dnstap.Message)) error {
+	reader, e := NewReader(input)
+	if e != nil {
+		return e
+	}
+
+	for {
+		packet, e := reader.Next()
+		if e != nil {
+			if e == io.EOF {
+				return nil
+			}
+			return e
+		}
+
+		for _, message := range decoder.Decode(packet) {
+			emit(message)
+		}
+	}
+}
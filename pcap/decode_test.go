@@ -0,0 +1,121 @@
+package pcap
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	dnstap "passivedns/dnstap"
+
+	"github.com/miekg/dns"
+)
+
+// the responses of a fixture, in order
+type expected struct {
+	name     string
+	protocol dnstap.SocketProtocol
+	family   dnstap.SocketFamily
+	rd       bool
+}
+
+func read(t *testing.T, file string, decoder *Decoder) []*dnstap.Message {
+	input, e := os.Open(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer input.Close()
+
+	messages := []*dnstap.Message{}
+	if e := Read(input, decoder, func(message *dnstap.Message) { messages = append(messages, message) }); e != nil {
+		t.Fatal(e)
+	}
+	return messages
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		file      string
+		responses []expected
+	}{
+		// pcap, UDP, an IPv4 datagram fragmented out of order and two TCP
+		// responses in segments out of order (with a retransmission)
+		{"testdata/dns.pcap", []expected{
+			{"udp.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, false},
+			{"www.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, false},
+			{"v6.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, false},
+			{"txt.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, false},
+			{"other.example.org.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, false},
+			{"frag.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, true},
+			{"tcp.example.com.", dnstap.SocketProtocol_TCP, dnstap.SocketFamily_INET, false},
+			{"tcp2.example.com.", dnstap.SocketProtocol_TCP, dnstap.SocketFamily_INET, false}}},
+		// pcapng, IPv6 UDP, a query (ignored), an IPv4 datagram fragmented in
+		// order and an IPv6 TCP response split across two segments
+		{"testdata/dns.pcapng", []expected{
+			{"v6.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET6, true},
+			{"frag.example.com.", dnstap.SocketProtocol_UDP, dnstap.SocketFamily_INET, true},
+			{"tcp.example.com.", dnstap.SocketProtocol_TCP, dnstap.SocketFamily_INET6, true}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			messages := read(t, test.file, NewDecoder())
+			if len(messages) != len(test.responses) {
+				t.Fatalf("got %v response(s), expected %v", len(messages), len(test.responses))
+			}
+
+			for i, message := range messages {
+				msg := new(dns.Msg)
+				if e := msg.Unpack(message.ResponseMessage); e != nil {
+					t.Fatalf("response %v: %v", i, e)
+				}
+				if msg.Question[0].Name != test.responses[i].name || len(msg.Answer) == 0 {
+					t.Errorf("response %v: got %v with %v answer(s), expected %v", i, msg.Question[0].Name, len(msg.Answer), test.responses[i].name)
+				}
+				if *message.SocketProtocol != test.responses[i].protocol || *message.SocketFamily != test.responses[i].family {
+					t.Errorf("response %v: got %v/%v, expected %v/%v", i, *message.SocketFamily, *message.SocketProtocol, test.responses[i].family, test.responses[i].protocol)
+				}
+				if msg.RecursionDesired != test.responses[i].rd {
+					t.Errorf("response %v: got RD %v, expected %v", i, msg.RecursionDesired, test.responses[i].rd)
+				}
+
+				// the response is sent from the server (port 53) to the client
+				if *message.ResponsePort != 53 || *message.QueryPort == 53 {
+					t.Errorf("response %v: got ports %v -> %v", i, *message.ResponsePort, *message.QueryPort)
+				}
+				if server := net.IP(message.ResponseAddress); !server.Equal(net.ParseIP("192.0.2.53")) && !server.Equal(net.ParseIP("2001:db8::53")) {
+					t.Errorf("response %v: got server %v", i, server)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderType(t *testing.T) {
+	tests := []struct {
+		kind     dnstap.Message_Type
+		expected func(rd bool) dnstap.Message_Type
+	}{
+		{dnstap.Message_RESOLVER_RESPONSE, func(bool) dnstap.Message_Type { return dnstap.Message_RESOLVER_RESPONSE }},
+		{dnstap.Message_CLIENT_RESPONSE, func(bool) dnstap.Message_Type { return dnstap.Message_CLIENT_RESPONSE }},
+		// zero, depending on the RD bit
+		{0, func(rd bool) dnstap.Message_Type {
+			if rd {
+				return dnstap.Message_CLIENT_RESPONSE
+			}
+			return dnstap.Message_RESOLVER_RESPONSE
+		}},
+	}
+
+	for _, test := range tests {
+		for _, file := range []string{"testdata/dns.pcap", "testdata/dns.pcapng"} {
+			decoder := NewDecoder()
+			decoder.Type = test.kind
+			for _, message := range read(t, file, decoder) {
+				rd := message.ResponseMessage[2]&0x01 != 0
+				if *message.Type != test.expected(rd) {
+					t.Errorf("%v with type %v: got %v for RD %v", file, test.kind, *message.Type, rd)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Link layer header types, see https://www.tcpdump.org/linktypes.html
+const (
+	LINKTYPE_NULL       uint32 = 0
+	LINKTYPE_ETHERNET   uint32 = 1
+	LINKTYPE_RAW_BSD    uint32 = 12
+	LINKTYPE_RAW_OBSD   uint32 = 14
+	LINKTYPE_RAW        uint32 = 101
+	LINKTYPE_LOOP       uint32 = 108
+	LINKTYPE_LINUX_SLL  uint32 = 113
+	LINKTYPE_IPV4       uint32 = 228
+	LINKTYPE_IPV6       uint32 = 229
+	LINKTYPE_LINUX_SLL2 uint32 = 276
+)
+
+const (
+	magicMicroseconds uint32 = 0xa1b2c3d4
+	magicNanoseconds  uint32 = 0xa1b23c4d
+	magicSection      uint32 = 0x0a0d0d0a
+	magicByteOrder    uint32 = 0x1a2b3c4d
+)
+
+// pcapng block types
+const (
+	blockInterfaceDescription uint32 = 0x00000001
+	blockPacket               uint32 = 0x00000002
+	blockSimplePacket         uint32 = 0x00000003
+	blockEnhancedPacket       uint32 = 0x00000006
+)
+
+// upper limit for a single packet or block, anything larger is considered a corrupt file
+const maxBlockSize uint32 = 16 * 1024 * 1024
+
+var ErrFormat = errors.New("not a pcap or pcapng file")
+
+// Packet is a single captured packet (link layer frame).
+type Packet struct {
+	Time     time.Time
+	LinkType uint32
+	Data     []byte
+}
+
+// Reader returns the packets of a pcap or pcapng file one at a time, Next
+// returns io.EOF when there are no more packets.
+type Reader interface {
+	Next() (Packet, error)
+}
+
+// Magic reports whether the (at least) four bytes of header start a pcap or
+// pcapng file.
+func Magic(header []byte) bool {
+	if len(header) < 4 {
+		return false
+	}
+	for _, magic := range []uint32{magicMicroseconds, magicNanoseconds, magicSection} {
+		if binary.BigEndian.Uint32(header) == magic || binary.LittleEndian.Uint32(header) == magic {
+			return true
+		}
+	}
+	return false
+}
+
+// NewReader detects the format (pcap or pcapng) of the input and returns a
+// reader for its packets.
+func NewReader(input io.Reader) (Reader, error) {
+	reader := bufio.NewReader(input)
+
+	header, e := reader.Peek(4)
+	if e != nil {
+		return nil, e
+	}
+
+	switch {
+	case binary.BigEndian.Uint32(header) == magicSection:
+		return newNgReader(reader)
+	case Magic(header):
+		return newPcapReader(reader)
+	default:
+		return nil, ErrFormat
+	}
+}
+
+type pcapReader struct {
+	input    io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linktype uint32
+	header   [16]byte
+}
+
+func newPcapReader(input io.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, e := io.ReadFull(input, header[:]); e != nil {
+		return nil, e
+	}
+
+	this := &pcapReader{input: input}
+	switch magic := binary.LittleEndian.Uint32(header[0:4]); magic {
+	case magicMicroseconds, magicNanoseconds:
+		this.order = binary.LittleEndian
+		this.nano = magic == magicNanoseconds
+	default:
+		this.order = binary.BigEndian
+		this.nano = binary.BigEndian.Uint32(header[0:4]) == magicNanoseconds
+	}
+	this.linktype = this.order.Uint32(header[20:24]) & 0x0fffffff
+
+	return this, nil
+}
+
+func (this *pcapReader) Next() (Packet, error) {
+	if _, e := io.ReadFull(this.input, this.header[:]); e != nil {
+		if e == io.ErrUnexpectedEOF {
+			return Packet{}, fmt.Errorf("truncated pcap record header")
+		}
+		return Packet{}, e
+	}
+
+	seconds := this.order.Uint32(this.header[0:4])
+	fraction := this.order.Uint32(this.header[4:8])
+	length := this.order.Uint32(this.header[8:12])
+	if maxBlockSize < length {
+		return Packet{}, fmt.Errorf("pcap record of %v bytes exceeds the %v bytes limit", length, maxBlockSize)
+	}
+
+	data := make([]byte, length)
+	if _, e := io.ReadFull(this.input, data); e != nil {
+		return Packet{}, fmt.Errorf("truncated pcap record: %v", e)
+	}
+
+	nanoseconds := int64(fraction)
+	if !this.nano {
+		nanoseconds *= 1000
+	}
+
+	return Packet{Time: time.Unix(int64(seconds), nanoseconds), LinkType: this.linktype, Data: data}, nil
+}
+
+type ngInterface struct {
+	linktype uint32
+	// number of timestamp units per second
+	resolution uint64
+}
+
+type ngReader struct {
+	input      io.Reader
+	order      binary.ByteOrder
+	interfaces []ngInterface
+}
+
+func newNgReader(input io.Reader) (*ngReader, error) {
+	this := &ngReader{input: input}
+	if _, _, e := this.block(); e != nil {
+		return nil, e
+	}
+	return this, nil
+}
+
+// read the next block and return its type, section header blocks are
+// handled (and returned) here since they determine the byte order
+func (this *ngReader) block() (uint32, []byte, error) {
+	var header [8]byte
+	if _, e := io.ReadFull(this.input, header[:]); e != nil {
+		if e == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("truncated pcapng block header")
+		}
+		return 0, nil, e
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) == magicSection {
+		var magic [4]byte
+		if _, e := io.ReadFull(this.input, magic[:]); e != nil {
+			return 0, nil, fmt.Errorf("truncated pcapng section header: %v", e)
+		}
+		if binary.LittleEndian.Uint32(magic[:]) == magicByteOrder {
+			this.order = binary.LittleEndian
+		} else if binary.BigEndian.Uint32(magic[:]) == magicByteOrder {
+			this.order = binary.BigEndian
+		} else {
+			return 0, nil, ErrFormat
+		}
+		// interface ids are local to a section
+		this.interfaces = this.interfaces[:0]
+
+		length := this.order.Uint32(header[4:8])
+		if length < 16 || maxBlockSize < length {
+			return 0, nil, fmt.Errorf("invalid pcapng section header length %v", length)
+		}
+		if _, e := io.CopyN(io.Discard, this.input, int64(length-12)); e != nil {
+			return 0, nil, fmt.Errorf("truncated pcapng section header: %v", e)
+		}
+		return magicSection, nil, nil
+	}
+
+	if this.order == nil {
+		return 0, nil, ErrFormat
+	}
+
+	length := this.order.Uint32(header[4:8])
+	if length < 12 || maxBlockSize < length || length%4 != 0 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %v", length)
+	}
+
+	body := make([]byte, length-8)
+	if _, e := io.ReadFull(this.input, body); e != nil {
+		return 0, nil, fmt.Errorf("truncated pcapng block: %v", e)
+	}
+
+	// strip the trailing (redundant) block length
+	return this.order.Uint32(header[0:4]), body[:len(body)-4], nil
+}
+
+func (this *ngReader) interfaceDescription(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("truncated pcapng interface description block")
+	}
+
+	description := ngInterface{linktype: uint32(this.order.Uint16(body[0:2])), resolution: 1000000}
+
+	// walk the options looking for if_tsresol
+	for options := body[8:]; 4 <= len(options); {
+		code := this.order.Uint16(options[0:2])
+		length := int(this.order.Uint16(options[2:4]))
+		if code == 0 || len(options) < 4+length {
+			break
+		}
+		if code == 9 && length == 1 {
+			resolution := options[4]
+			if resolution&0x80 == 0 {
+				description.resolution = uint64(math.Pow10(int(resolution)))
+			} else {
+				description.resolution = uint64(1) << (resolution & 0x7f)
+			}
+		}
+		if padded := 4 + (length+3)&^3; padded <= len(options) {
+			options = options[padded:]
+		} else {
+			break
+		}
+	}
+
+	this.interfaces = append(this.interfaces, description)
+	return nil
+}
+
+func (this *ngReader) timestamp(id uint32, high, low uint32) time.Time {
+	units := uint64(high)<<32 | uint64(low)
+	resolution := this.interfaces[id].resolution
+	if resolution == 0 {
+		resolution = 1000000
+	}
+	seconds := units / resolution
+	nanoseconds := (units % resolution) * 1000000000 / resolution
+	return time.Unix(int64(seconds), int64(nanoseconds))
+}
+
+func (this *ngReader) Next() (Packet, error) {
+	for {
+		kind, body, e := this.block()
+		if e != nil {
+			return Packet{}, e
+		}
+
+		switch kind {
+		case blockInterfaceDescription:
+			if e := this.interfaceDescription(body); e != nil {
+				return Packet{}, e
+			}
+
+		case blockEnhancedPacket:
+			if len(body) < 20 {
+				return Packet{}, fmt.Errorf("truncated pcapng enhanced packet block")
+			}
+			id := this.order.Uint32(body[0:4])
+			length := this.order.Uint32(body[12:16])
+			if int(id) >= len(this.interfaces) || uint32(len(body)-20) < length {
+				return Packet{}, fmt.Errorf("invalid pcapng enhanced packet block")
+			}
+			return Packet{
+				Time:     this.timestamp(id, this.order.Uint32(body[4:8]), this.order.Uint32(body[8:12])),
+				LinkType: this.interfaces[id].linktype,
+				Data:     body[20 : 20+length]}, nil
+
+		case blockPacket:
+			// obsolete, but still written by some tools
+			if len(body) < 20 {
+				return Packet{}, fmt.Errorf("truncated pcapng packet block")
+			}
+			id := uint32(this.order.Uint16(body[0:2]))
+			length := this.order.Uint32(body[12:16])
+			if int(id) >= len(this.interfaces) || uint32(len(body)-20) < length {
+				return Packet{}, fmt.Errorf("invalid pcapng packet block")
+			}
+			return Packet{
+				Time:     this.timestamp(id, this.order.Uint32(body[4:8]), this.order.Uint32(body[8:12])),
+				LinkType: this.interfaces[id].linktype,
+				Data:     body[20 : 20+length]}, nil
+
+		case blockSimplePacket:
+			// no timestamp and always captured on the first interface
+			if len(body) < 4 || len(this.interfaces) == 0 {
+				return Packet{}, fmt.Errorf("invalid pcapng simple packet block")
+			}
+			length := this.order.Uint32(body[0:4])
+			if uint32(len(body)-4) < length {
+				length = uint32(len(body) - 4)
+			}
+			return Packet{LinkType: this.interfaces[0].linktype, Data: body[4 : 4+length]}, nil
+
+		default:
+			// skip name resolution, statistics and custom blocks
+		}
+	}
+}
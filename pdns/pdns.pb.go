@@ -0,0 +1,369 @@
+// passivedns: passive DNS records as published by passivedns (e.g. to Kafka or
+// to gRPC subscribers)
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        (unknown)
+// source: pdns.proto
+
+package pdns
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Answer is a single resource record of a DNS response, as observed by a
+// sensor (DNS server).
+type Answer struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// time the response was sent, Unix seconds and nanoseconds
+	TimeSec  uint64 `protobuf:"varint,1,opt,name=time_sec,json=timeSec,proto3" json:"time_sec,omitempty"`
+	TimeNsec uint32 `protobuf:"varint,2,opt,name=time_nsec,json=timeNsec,proto3" json:"time_nsec,omitempty"`
+	// identity of the sensor (the Dnstap identity), may be empty
+	Sensor string `protobuf:"bytes,3,opt,name=sensor,proto3" json:"sensor,omitempty"`
+	// DNS message ID
+	Id uint32 `protobuf:"varint,4,opt,name=id,proto3" json:"id,omitempty"`
+	// owner name, without the trailing dot
+	Name string `protobuf:"bytes,5,opt,name=name,proto3" json:"name,omitempty"`
+	Ttl  uint32 `protobuf:"varint,6,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	// class and type mnemonics, e.g. "IN" and "AAAA"
+	Class string `protobuf:"bytes,7,opt,name=class,proto3" json:"class,omitempty"`
+	Type  string `protobuf:"bytes,8,opt,name=type,proto3" json:"type,omitempty"`
+	// presentation format of the data, e.g. an address or a name without the
+	// trailing dot
+	Data string `protobuf:"bytes,9,opt,name=data,proto3" json:"data,omitempty"`
+	// network addresses (4 or 16 bytes) of the querier and the responder,
+	// e.g. the resolver and the authoritative server
+	QueryAddress    []byte `protobuf:"bytes,10,opt,name=query_address,json=queryAddress,proto3" json:"query_address,omitempty"`
+	ResponseAddress []byte `protobuf:"bytes,11,opt,name=response_address,json=responseAddress,proto3" json:"response_address,omitempty"`
+	// the question of the response, the name without the trailing dot and the
+	// type and class mnemonics, e.g. the name queried of a CNAME-expanded A
+	// record (empty if the response has none)
+	QuestionName  string `protobuf:"bytes,12,opt,name=question_name,json=questionName,proto3" json:"question_name,omitempty"`
+	QuestionType  string `protobuf:"bytes,13,opt,name=question_type,json=questionType,proto3" json:"question_type,omitempty"`
+	QuestionClass string `protobuf:"bytes,14,opt,name=question_class,json=questionClass,proto3" json:"question_class,omitempty"`
+}
+
+func (x *Answer) Reset() {
+	*x = Answer{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdns_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Answer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Answer) ProtoMessage() {}
+
+func (x *Answer) ProtoReflect() protoreflect.Message {
+	mi := &file_pdns_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Answer.ProtoReflect.Descriptor instead.
+func (*Answer) Descriptor() ([]byte, []int) {
+	return file_pdns_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Answer) GetTimeSec() uint64 {
+	if x != nil {
+		return x.TimeSec
+	}
+	return 0
+}
+
+func (x *Answer) GetTimeNsec() uint32 {
+	if x != nil {
+		return x.TimeNsec
+	}
+	return 0
+}
+
+func (x *Answer) GetSensor() string {
+	if x != nil {
+		return x.Sensor
+	}
+	return ""
+}
+
+func (x *Answer) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Answer) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Answer) GetTtl() uint32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+func (x *Answer) GetClass() string {
+	if x != nil {
+		return x.Class
+	}
+	return ""
+}
+
+func (x *Answer) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Answer) GetData() string {
+	if x != nil {
+		return x.Data
+	}
+	return ""
+}
+
+func (x *Answer) GetQueryAddress() []byte {
+	if x != nil {
+		return x.QueryAddress
+	}
+	return nil
+}
+
+func (x *Answer) GetResponseAddress() []byte {
+	if x != nil {
+		return x.ResponseAddress
+	}
+	return nil
+}
+
+func (x *Answer) GetQuestionName() string {
+	if x != nil {
+		return x.QuestionName
+	}
+	return ""
+}
+
+func (x *Answer) GetQuestionType() string {
+	if x != nil {
+		return x.QuestionType
+	}
+	return ""
+}
+
+func (x *Answer) GetQuestionClass() string {
+	if x != nil {
+		return x.QuestionClass
+	}
+	return ""
+}
+
+// SubscribeRequest selects the answers streamed to a subscriber, an empty
+// list matches all answers.
+type SubscribeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// type mnemonics, e.g. "A" and "AAAA"
+	Types []string `protobuf:"bytes,1,rep,name=types,proto3" json:"types,omitempty"`
+	// name suffixes, e.g. "example.com" matches example.com and
+	// www.example.com but not badexample.com
+	Suffixes []string `protobuf:"bytes,2,rep,name=suffixes,proto3" json:"suffixes,omitempty"`
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pdns_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_pdns_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_pdns_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubscribeRequest) GetTypes() []string {
+	if x != nil {
+		return x.Types
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetSuffixes() []string {
+	if x != nil {
+		return x.Suffixes
+	}
+	return nil
+}
+
+var File_pdns_proto protoreflect.FileDescriptor
+
+var file_pdns_proto_rawDesc = []byte{
+	0x0a, 0x0a, 0x70, 0x64, 0x6e, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04, 0x70, 0x64,
+	0x6e, 0x73, 0x22, 0x8d, 0x03, 0x0a, 0x06, 0x41, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x12, 0x19, 0x0a,
+	0x08, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x73, 0x65, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x07, 0x74, 0x69, 0x6d, 0x65, 0x53, 0x65, 0x63, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65,
+	0x5f, 0x6e, 0x73, 0x65, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x74, 0x69, 0x6d,
+	0x65, 0x4e, 0x73, 0x65, 0x63, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x65, 0x6e, 0x73, 0x6f, 0x72, 0x12, 0x0e, 0x0a,
+	0x02, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d,
+	0x65, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x74, 0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03,
+	0x74, 0x74, 0x6c, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70,
+	0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x64, 0x61, 0x74,
+	0x61, 0x12, 0x23, 0x0a, 0x0d, 0x71, 0x75, 0x65, 0x72, 0x79, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65,
+	0x73, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0c, 0x71, 0x75, 0x65, 0x72, 0x79, 0x41,
+	0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x0f, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73,
+	0x73, 0x12, 0x23, 0x0a, 0x0d, 0x71, 0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x71, 0x75, 0x65, 0x73, 0x74, 0x69,
+	0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x71, 0x75, 0x65, 0x73, 0x74, 0x69,
+	0x6f, 0x6e, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x79, 0x70, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x0e, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0d, 0x71, 0x75, 0x65, 0x73, 0x74, 0x69, 0x6f, 0x6e, 0x43, 0x6c, 0x61,
+	0x73, 0x73, 0x22, 0x44, 0x0a, 0x10, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x79, 0x70, 0x65, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x74, 0x79, 0x70, 0x65, 0x73, 0x12, 0x1a, 0x0a, 0x08,
+	0x73, 0x75, 0x66, 0x66, 0x69, 0x78, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08,
+	0x73, 0x75, 0x66, 0x66, 0x69, 0x78, 0x65, 0x73, 0x32, 0x41, 0x0a, 0x0a, 0x50, 0x61, 0x73, 0x73,
+	0x69, 0x76, 0x65, 0x44, 0x6e, 0x73, 0x12, 0x33, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72,
+	0x69, 0x62, 0x65, 0x12, 0x16, 0x2e, 0x70, 0x64, 0x6e, 0x73, 0x2e, 0x53, 0x75, 0x62, 0x73, 0x63,
+	0x72, 0x69, 0x62, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0c, 0x2e, 0x70, 0x64,
+	0x6e, 0x73, 0x2e, 0x41, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x30, 0x01, 0x42, 0x11, 0x5a, 0x0f, 0x70,
+	0x61, 0x73, 0x73, 0x69, 0x76, 0x65, 0x64, 0x6e, 0x73, 0x2f, 0x70, 0x64, 0x6e, 0x73, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_pdns_proto_rawDescOnce sync.Once
+	file_pdns_proto_rawDescData = file_pdns_proto_rawDesc
+)
+
+func file_pdns_proto_rawDescGZIP() []byte {
+	file_pdns_proto_rawDescOnce.Do(func() {
+		file_pdns_proto_rawDescData = protoimpl.X.CompressGZIP(file_pdns_proto_rawDescData)
+	})
+	return file_pdns_proto_rawDescData
+}
+
+var file_pdns_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_pdns_proto_goTypes = []interface{}{
+	(*Answer)(nil),           // 0: pdns.Answer
+	(*SubscribeRequest)(nil), // 1: pdns.SubscribeRequest
+}
+var file_pdns_proto_depIdxs = []int32{
+	1, // 0: pdns.PassiveDns.Subscribe:input_type -> pdns.SubscribeRequest
+	0, // 1: pdns.PassiveDns.Subscribe:output_type -> pdns.Answer
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_pdns_proto_init() }
+func file_pdns_proto_init() {
+	if File_pdns_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_pdns_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Answer); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_pdns_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SubscribeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_pdns_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_pdns_proto_goTypes,
+		DependencyIndexes: file_pdns_proto_depIdxs,
+		MessageInfos:      file_pdns_proto_msgTypes,
+	}.Build()
+	File_pdns_proto = out.File
+	file_pdns_proto_rawDesc = nil
+	file_pdns_proto_goTypes = nil
+	file_pdns_proto_depIdxs = nil
+}
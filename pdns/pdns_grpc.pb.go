@@ -0,0 +1,132 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pdns
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PassiveDnsClient is the client API for PassiveDns service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PassiveDnsClient interface {
+	// answers matching the request until the subscriber cancels or the server
+	// stops, answers are dropped rather than buffered for slow subscribers
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (PassiveDns_SubscribeClient, error)
+}
+
+type passiveDnsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPassiveDnsClient(cc grpc.ClientConnInterface) PassiveDnsClient {
+	return &passiveDnsClient{cc}
+}
+
+func (c *passiveDnsClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (PassiveDns_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PassiveDns_ServiceDesc.Streams[0], "/pdns.PassiveDns/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &passiveDnsSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PassiveDns_SubscribeClient interface {
+	Recv() (*Answer, error)
+	grpc.ClientStream
+}
+
+type passiveDnsSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *passiveDnsSubscribeClient) Recv() (*Answer, error) {
+	m := new(Answer)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PassiveDnsServer is the server API for PassiveDns service.
+// All implementations must embed UnimplementedPassiveDnsServer
+// for forward compatibility
+type PassiveDnsServer interface {
+	// answers matching the request until the subscriber cancels or the server
+	// stops, answers are dropped rather than buffered for slow subscribers
+	Subscribe(*SubscribeRequest, PassiveDns_SubscribeServer) error
+	mustEmbedUnimplementedPassiveDnsServer()
+}
+
+// UnimplementedPassiveDnsServer must be embedded to have forward compatible implementations.
+type UnimplementedPassiveDnsServer struct {
+}
+
+func (UnimplementedPassiveDnsServer) Subscribe(*SubscribeRequest, PassiveDns_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedPassiveDnsServer) mustEmbedUnimplementedPassiveDnsServer() {}
+
+// UnsafePassiveDnsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PassiveDnsServer will
+// result in compilation errors.
+type UnsafePassiveDnsServer interface {
+	mustEmbedUnimplementedPassiveDnsServer()
+}
+
+func RegisterPassiveDnsServer(s grpc.ServiceRegistrar, srv PassiveDnsServer) {
+	s.RegisterService(&PassiveDns_ServiceDesc, srv)
+}
+
+func _PassiveDns_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PassiveDnsServer).Subscribe(m, &passiveDnsSubscribeServer{stream})
+}
+
+type PassiveDns_SubscribeServer interface {
+	Send(*Answer) error
+	grpc.ServerStream
+}
+
+type passiveDnsSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *passiveDnsSubscribeServer) Send(m *Answer) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// PassiveDns_ServiceDesc is the grpc.ServiceDesc for PassiveDns service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PassiveDns_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pdns.PassiveDns",
+	HandlerType: (*PassiveDnsServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _PassiveDns_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pdns.proto",
+}
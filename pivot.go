@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upper limits for the hops and the fan-out of a pivot (e.g. requested over
+// HTTP), and the number of nodes of a pivot over HTTP
+const (
+	PIVOTMAXHOPS   = 5
+	PIVOTMAXFANOUT = 100
+	PIVOTMAXNODES  = 500
+)
+
+// output formats of the pivot graph
+const (
+	GRAPHJSON = "json"
+	GRAPHDOT  = "dot"
+)
+
+// kinds of graph nodes
+const (
+	NODENAME    = "name"
+	NODEADDRESS = "address"
+)
+
+// GraphNode is a name or an address, hops is the distance to the start, a
+// truncated node has more neighbours than the fan-out limit
+type GraphNode struct {
+	Id        string `json:"id"`
+	Kind      string `json:"kind"`
+	Hops      int    `json:"hops"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// GraphEdge is a record from a name to an address (A, AAAA) or to a name (CNAME)
+type GraphEdge struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Type      string    `json:"type"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Graph is the result of a walk, a truncated graph reached the node limit
+type Graph struct {
+	Nodes     []GraphNode `json:"nodes"`
+	Edges     []GraphEdge `json:"edges"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// the records of a node, names resolve to addresses and CNAME targets and are
+// the targets of CNAMEs, addresses are the data of A and AAAA records
+func neighbours(store Store, node GraphNode, query Query) ([]Record, error) {
+	if node.Kind == NODEADDRESS {
+		query.Types = []string{"A", "AAAA"}
+		return store.Data(node.Id, query)
+	}
+
+	// the name itself, not a pattern (e.g. the target of a wildcard CNAME)
+	query.Types = []string{"A", "AAAA", "CNAME"}
+	records, e := store.Name(strings.ReplaceAll(node.Id, "*", `\*`), query)
+	if e != nil {
+		return nil, e
+	}
+
+	query.Types = []string{"CNAME"}
+	aliases, e := store.Data(node.Id, query)
+	if e != nil {
+		return nil, e
+	}
+	return append(records, aliases...), nil
+}
+
+// walk the records from a name or an address up to a number of hops, at most
+// fanout (the most often seen) records of a node are followed and at most
+// limit nodes are added (0 is no limit). Each node is expanded once, the walk
+// ends early when the context is done. Names are lower cased, the case
+// variants (0x20) of a name are one node.
+func walkGraph(context context.Context, store Store, start string, hops int, fanout int, limit int, query Query) (*Graph, error) {
+	graph := &Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	nodes := make(map[string]int)
+	add := func(id string, kind string, distance int) bool {
+		if _, ok := nodes[id]; !ok {
+			if 0 < limit && limit <= len(graph.Nodes) {
+				graph.Truncated = true
+				return false
+			}
+			nodes[id] = len(graph.Nodes)
+			graph.Nodes = append(graph.Nodes, GraphNode{Id: id, Kind: kind, Hops: distance})
+		}
+		return true
+	}
+	if ip := net.ParseIP(start); ip != nil {
+		add(ip.String(), NODEADDRESS, 0)
+	} else {
+		add(strings.ToLower(strings.TrimRight(start, ".")), NODENAME, 0)
+	}
+
+	type key struct{ from, to, rrtype string }
+	edges, seen := make(map[key]int), make(map[key]bool)
+
+	// breadth first, the nodes are appended while walking
+	for i := 0; i < len(graph.Nodes) && graph.Nodes[i].Hops < hops; i++ {
+		if e := context.Err(); e != nil {
+			return nil, e
+		}
+
+		node := graph.Nodes[i]
+		records, e := neighbours(store, node, query)
+		if e != nil {
+			return nil, e
+		}
+
+		if 0 < fanout && fanout < len(records) {
+			sort.SliceStable(records, func(i, j int) bool { return records[j].Count < records[i].Count })
+			records = records[:fanout]
+			graph.Nodes[i].Truncated = true
+		}
+
+		for _, record := range records {
+			from, to, kind := strings.ToLower(record.Name), record.Data, NODEADDRESS
+			if record.Type == "CNAME" {
+				to, kind = strings.ToLower(record.Data), NODENAME
+			}
+
+			// a record is found from both of its ends
+			if seen[key{record.Name, record.Data, record.Type}] {
+				continue
+			}
+			seen[key{record.Name, record.Data, record.Type}] = true
+
+			// case variants of a record are one edge
+			k := key{from, to, record.Type}
+			if i, ok := edges[k]; ok {
+				edge := &graph.Edges[i]
+				edge.Count += record.Count
+				if record.FirstSeen.Before(edge.FirstSeen) {
+					edge.FirstSeen = record.FirstSeen
+				}
+				if edge.LastSeen.Before(record.LastSeen) {
+					edge.LastSeen = record.LastSeen
+				}
+				continue
+			}
+
+			// the other end of the record, the edge is left out beyond the limit
+			if from != node.Id && !add(from, NODENAME, node.Hops+1) {
+				continue
+			}
+			if !add(to, kind, node.Hops+1) {
+				continue
+			}
+			edges[k] = len(graph.Edges)
+
+			graph.Edges = append(graph.Edges, GraphEdge{From: from, To: to, Type: record.Type, Count: record.Count, FirstSeen: record.FirstSeen, LastSeen: record.LastSeen})
+		}
+	}
+
+	return graph, nil
+}
+
+func (this *Graph) Json(output io.Writer) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(this)
+}
+
+// Dot writes the graph in the Graphviz DOT language, names are ellipses,
+// addresses boxes and truncated nodes dashed
+func (this *Graph) Dot(output io.Writer) error {
+	fmt.Fprintln(output, "digraph pdns {")
+	fmt.Fprintln(output, "\trankdir=LR;")
+	if this.Truncated {
+		fmt.Fprintf(output, "\tlabel=%q;\n", fmt.Sprintf("truncated at %d nodes", len(this.Nodes)))
+	}
+	for _, node := range this.Nodes {
+		shape, style := "ellipse", "solid"
+		if node.Kind == NODEADDRESS {
+			shape = "box"
+		}
+		if node.Truncated {
+			style = "dashed"
+		}
+		if node.Hops == 0 {
+			style += ",bold"
+		}
+		fmt.Fprintf(output, "\t%s [shape=%s, style=%q];\n", strconv.Quote(node.Id), shape, style)
+	}
+	for _, edge := range this.Edges {
+		fmt.Fprintf(output, "\t%s -> %s [label=%q];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), fmt.Sprintf("%s (%d)", edge.Type, edge.Count))
+	}
+	_, e := fmt.Fprintln(output, "}")
+	return e
+}
+
+// walk the records of a store from a name or an address, "passivedns pivot
+// -sqlite pdns.db -hops 3 www.example.com", as JSON or DOT
+func pivot(args []string) {
+	flags := flag.NewFlagSet("pivot", flag.ExitOnError)
+	options := newQueryOptions(flags)
+	hops := flags.Int("hops", 2, fmt.Sprintf("Number of hops from the name or address (at most %v)", PIVOTMAXHOPS))
+	fanout := flags.Int("fanout", 50, "Follow at most this many (the most often seen) records per name or address, 0 follows all")
+	limit := flags.Int("max-nodes", 0, "Stop adding nodes to the graph at this many, 0 is no limit")
+	format := flags.String("format", GRAPHJSON, "Output format [json, dot]")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: passivedns pivot [options] <name or address>\n")
+		flags.PrintDefaults()
+	}
+	start := queryArguments(flags, args, 1)[0]
+
+	if *hops < 0 || PIVOTMAXHOPS < *hops {
+		fatalf("Invalid argument -hops %v, expected 0 to %v\n", *hops, PIVOTMAXHOPS)
+	}
+	if *format != GRAPHJSON && *format != GRAPHDOT {
+		fatalf("Invalid argument -format %v, expected json or dot\n", *format)
+	}
+
+	store, filter := options.open()
+	defer store.Close()
+
+	graph, e := walkGraph(context.Background(), store, start, *hops, *fanout, *limit, filter)
+	if e != nil {
+		fatalln(e)
+	}
+
+	if *format == GRAPHDOT {
+		e = graph.Dot(os.Stdout)
+	} else {
+		e = graph.Json(os.Stdout)
+	}
+	if e != nil {
+		fatalln(e)
+	}
+	if graph.Truncated {
+		fmt.Fprintf(os.Stderr, "Found %v node(s) and %v edge(s), truncated at -max-nodes\n", len(graph.Nodes), len(graph.Edges))
+	} else {
+		fmt.Fprintf(os.Stderr, "Found %v node(s) and %v edge(s)\n", len(graph.Nodes), len(graph.Edges))
+	}
+}
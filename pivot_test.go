@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryStore looks records up like the stores, names by pattern (see
+// namePattern) and case insensitively, data exactly
+type memoryStore struct {
+	records []Record
+}
+
+func (this *memoryStore) Name(name string, query Query) ([]Record, error) {
+	kind, name, e := namePattern(name)
+	if e != nil {
+		return nil, e
+	}
+	return this.lookup(func(record Record) bool {
+		owner := strings.ToLower(record.Name)
+		switch kind {
+		case NAMEBELOW:
+			return strings.HasSuffix(owner, "."+name) || (name == "" && owner != "")
+		case NAMEPREFIX:
+			return strings.HasPrefix(owner, name+".")
+		default:
+			return owner == name
+		}
+	}, query), nil
+}
+
+func (this *memoryStore) Data(data string, query Query) ([]Record, error) {
+	return this.lookup(func(record Record) bool { return record.Data == data }, query), nil
+}
+
+func (this *memoryStore) Suffix(suffix string, query Query) ([]Record, error) {
+	return nil, nil
+}
+
+func (this *memoryStore) Network(network *net.IPNet, query Query) ([]Record, error) {
+	return nil, nil
+}
+
+func (this *memoryStore) Resolve(name string, query Query) ([]Resolution, error) {
+	return nil, nil
+}
+
+func (this *memoryStore) Close() error {
+	return nil
+}
+
+func (this *memoryStore) lookup(match func(Record) bool, query Query) []Record {
+	records := []Record{}
+	for _, record := range this.records {
+		typed := len(query.Types) == 0
+		for _, rrtype := range query.Types {
+			typed = typed || rrtype == record.Type
+		}
+		if typed && match(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func TestWalkGraph(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	record := func(name string, rrtype string, data string, count int64) Record {
+		return Record{Name: name, Class: "IN", Type: rrtype, Data: data, Count: count, FirstSeen: seen, LastSeen: seen.Add(time.Duration(count) * time.Hour)}
+	}
+	store := &memoryStore{records: []Record{
+		// case variants (0x20) of one record
+		record("www.example.com", "A", "192.0.2.1", 2),
+		record("WwW.ExAmple.com", "A", "192.0.2.1", 3),
+		record("Web.Example.com", "CNAME", "www.example.com", 1),
+		// a wildcard CNAME target, not a pattern
+		record("alias.example.com", "CNAME", "*.cdn.example.net", 1),
+		record("*.cdn.example.net", "A", "198.51.100.1", 1),
+		record("other.cdn.example.net", "A", "198.51.100.2", 1),
+	}}
+
+	tests := []struct {
+		start string
+		hops  int
+		nodes []string
+		edges []string
+	}{
+		{"WWW.example.com.", 2,
+			[]string{"www.example.com name 0", "192.0.2.1 address 1", "web.example.com name 1"},
+			[]string{"www.example.com A 192.0.2.1 5", "web.example.com CNAME www.example.com 1"}},
+		{"192.0.2.1", 2,
+			[]string{"192.0.2.1 address 0", "www.example.com name 1", "web.example.com name 2"},
+			[]string{"www.example.com A 192.0.2.1 5", "web.example.com CNAME www.example.com 1"}},
+		{"alias.example.com", 3,
+			[]string{"alias.example.com name 0", "*.cdn.example.net name 1", "198.51.100.1 address 2"},
+			[]string{"alias.example.com CNAME *.cdn.example.net 1", "*.cdn.example.net A 198.51.100.1 1"}},
+	}
+
+	for _, test := range tests {
+		graph, e := walkGraph(context.Background(), store, test.start, test.hops, 0, 0, Query{})
+		if e != nil {
+			t.Fatalf("%v: %v", test.start, e)
+		}
+		nodes, edges := []string{}, []string{}
+		for _, node := range graph.Nodes {
+			nodes = append(nodes, fmt.Sprintf("%v %v %v", node.Id, node.Kind, node.Hops))
+		}
+		for _, edge := range graph.Edges {
+			edges = append(edges, fmt.Sprintf("%v %v %v %v", edge.From, edge.Type, edge.To, edge.Count))
+		}
+		if fmt.Sprint(nodes) != fmt.Sprint(test.nodes) {
+			t.Errorf("%v: got nodes %q, expected %q", test.start, nodes, test.nodes)
+		}
+		if fmt.Sprint(edges) != fmt.Sprint(test.edges) {
+			t.Errorf("%v: got edges %q, expected %q", test.start, edges, test.edges)
+		}
+	}
+}
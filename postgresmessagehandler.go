@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// rows per INSERT statement, PostgreSQL allows at most 65535 parameters per statement
+const POSTGRESROWS = 1000
+
+// postgresWriter writes the answers to a PostgreSQL database. Unlike the
+// SQLite store, which keeps every answer, the answers are aggregated into
+// records (count, first and last seen) which are upserted in batches.
+type postgresWriter struct {
+	database *sql.DB
+}
+
+func (this *postgresWriter) insert(answers []Answer) error {
+	// a record can only be upserted once per statement, case variants
+	// included
+	records := aggregateFolded(answers)
+
+	transaction, e := this.database.Begin()
+	if e != nil {
+		return e
+	}
+
+	for 0 < len(records) {
+		chunk := records
+		if POSTGRESROWS < len(chunk) {
+			chunk = chunk[:POSTGRESROWS]
+		}
+		records = records[len(chunk):]
+
+		values := make([]string, 0, len(chunk))
+		arguments := make([]interface{}, 0, 9*len(chunk))
+		for i, record := range chunk {
+			n := 9 * i
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d::inet, $%d::bigint, $%d::timestamptz, $%d::timestamptz)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9))
+
+			// addresses are also stored as such, for network (CIDR) queries
+			var address interface{}
+			if record.Type == "A" || record.Type == "AAAA" {
+				address = record.Data
+			}
+			arguments = append(arguments, record.Name, reverse(record.Name), record.Class, record.Type, record.Data, address, record.Count, record.FirstSeen, record.LastSeen)
+		}
+
+		statement := "INSERT INTO records (name, rname, class, type, data, address, count, first_seen, last_seen) VALUES " + strings.Join(values, ", ") +
+			" ON CONFLICT (name, class, type, data) DO UPDATE SET count = records.count + EXCLUDED.count, first_seen = LEAST(records.first_seen, EXCLUDED.first_seen), last_seen = GREATEST(records.last_seen, EXCLUDED.last_seen)"
+		if _, e := transaction.Exec(statement, arguments...); e != nil {
+			transaction.Rollback()
+			return e
+		}
+	}
+
+	return transaction.Commit()
+}
+
+func (this *postgresWriter) close() {
+	this.database.Close()
+}
+
+// create the schema if needed, the reversed name (e.g. "com.example.www")
+// makes suffix searches (e.g. all names in "example.com") prefix searches
+func postgresCreate(db *sql.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS records (
+			name TEXT NOT NULL,
+			rname TEXT NOT NULL,
+			class TEXT NOT NULL,
+			type TEXT NOT NULL,
+			data TEXT NOT NULL,
+			address INET,
+			count BIGINT NOT NULL,
+			first_seen TIMESTAMPTZ NOT NULL,
+			last_seen TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (name, class, type, data))`,
+		"CREATE INDEX IF NOT EXISTS idx_records_rname ON records (rname text_pattern_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_records_data ON records (data)",
+		"CREATE INDEX IF NOT EXISTS idx_records_address ON records USING gist (address inet_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_records_last_seen ON records (last_seen)"}
+
+	for _, statement := range statements {
+		if _, e := db.Exec(statement); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// NewPostgresWriter creates the writer shared by the PostgreSQL message
+// handlers of all workers, database is a connection string, e.g.
+// "postgres://pdns@localhost/pdns?sslmode=disable".
+func NewPostgresWriter(database string, size int, age time.Duration) *answerWriter {
+	fmt.Fprintf(os.Stderr, "Creating PostgreSQL writer\n")
+
+	db, e := sql.Open("postgres", database)
+	if e != nil {
+		fatalln(e)
+	}
+	if e := postgresCreate(db); e != nil {
+		fatalln(e)
+	}
+
+	return newAnswerWriter("PostgreSQL", &postgresWriter{database: db}, STORETYPES, size, age)
+}
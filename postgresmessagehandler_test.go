@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// a PostgreSQL database for the tests, e.g.
+// "postgres://pdns@localhost/pdns?sslmode=disable", the tests create (and drop)
+// a schema of their own in it
+func postgresTest(t *testing.T) *postgresWriter {
+	database := os.Getenv("PDNS_POSTGRES_DSN")
+	if database == "" {
+		t.Skip("PDNS_POSTGRES_DSN not set")
+	}
+
+	db, e := sql.Open("postgres", database)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// the search path is set per connection
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	schema := fmt.Sprintf("pdns_test_%d", os.Getpid())
+	for _, statement := range []string{"DROP SCHEMA IF EXISTS " + schema + " CASCADE", "CREATE SCHEMA " + schema, "SET search_path TO " + schema} {
+		if _, e := db.Exec(statement); e != nil {
+			t.Fatal(e)
+		}
+	}
+	t.Cleanup(func() { db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE") })
+
+	if e := postgresCreate(db); e != nil {
+		t.Fatal(e)
+	}
+	return &postgresWriter{database: db}
+}
+
+func postgresAnswer(seen time.Time, name string, rrtype uint16, data string) Answer {
+	return Answer{Time: seen, Name: name, Class: dns.ClassINET, Type: rrtype, Data: data}
+}
+
+// batches merge into the existing records, the counts add up and the seen
+// times widen, the case variants of a name are one record
+func TestPostgresUpsert(t *testing.T) {
+	writer := postgresTest(t)
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	batches := [][]Answer{
+		{postgresAnswer(seen.Add(time.Hour), "www.example.com.", dns.TypeA, "192.0.2.1"),
+			postgresAnswer(seen.Add(2*time.Hour), "www.example.com.", dns.TypeA, "192.0.2.1"),
+			postgresAnswer(seen.Add(time.Hour), "www.example.com.", dns.TypeAAAA, "2001:db8::1")},
+		// earlier and later than the first batch, case variants (0x20) in one batch
+		{postgresAnswer(seen, "WWW.Example.COM.", dns.TypeA, "192.0.2.1"),
+			postgresAnswer(seen.Add(3*time.Hour), "www.example.com.", dns.TypeA, "192.0.2.1"),
+			postgresAnswer(seen, "Web.Example.com.", dns.TypeCNAME, "WwW.example.com.")},
+		// within the range seen so far
+		{postgresAnswer(seen.Add(90*time.Minute), "wWw.eXample.com.", dns.TypeA, "192.0.2.1")},
+	}
+	for _, batch := range batches {
+		if e := writer.insert(batch); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	tests := []struct {
+		name, rrtype, data string
+		count              int64
+		first, last        time.Time
+	}{
+		{"www.example.com", "A", "192.0.2.1", 5, seen, seen.Add(3 * time.Hour)},
+		{"www.example.com", "AAAA", "2001:db8::1", 1, seen.Add(time.Hour), seen.Add(time.Hour)},
+		{"web.example.com", "CNAME", "www.example.com", 1, seen, seen},
+	}
+	for _, test := range tests {
+		var count int64
+		var first, last time.Time
+		row := writer.database.QueryRow("SELECT count, first_seen, last_seen FROM records WHERE name = $1 AND class = 'IN' AND type = $2 AND data = $3", test.name, test.rrtype, test.data)
+		if e := row.Scan(&count, &first, &last); e != nil {
+			t.Fatalf("%v %v %v: %v", test.name, test.rrtype, test.data, e)
+		}
+		if count != test.count || !first.Equal(test.first) || !last.Equal(test.last) {
+			t.Errorf("%v %v %v: got %v %v %v, expected %v %v %v", test.name, test.rrtype, test.data, count, first, last, test.count, test.first, test.last)
+		}
+	}
+
+	var records int
+	if e := writer.database.QueryRow("SELECT COUNT(*) FROM records").Scan(&records); e != nil {
+		t.Fatal(e)
+	}
+	if records != len(tests) {
+		t.Errorf("got %v record(s), expected %v", records, len(tests))
+	}
+}
+
+// network (CIDR) lookups of the addresses, using the GiST index
+func TestPostgresNetwork(t *testing.T) {
+	writer := postgresTest(t)
+
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	answers := []Answer{
+		postgresAnswer(seen, "a.example.com.", dns.TypeA, "192.0.2.1"),
+		postgresAnswer(seen, "b.example.com.", dns.TypeA, "192.0.2.200"),
+		postgresAnswer(seen, "c.example.com.", dns.TypeA, "198.51.100.1"),
+		postgresAnswer(seen, "a.example.com.", dns.TypeAAAA, "2001:db8::1"),
+		postgresAnswer(seen, "d.example.com.", dns.TypeAAAA, "2001:db8:1::1"),
+		// no address
+		postgresAnswer(seen, "e.example.com.", dns.TypeCNAME, "a.example.com."),
+	}
+	if e := writer.insert(answers); e != nil {
+		t.Fatal(e)
+	}
+
+	// the table is tiny, make the planner use the index anyway
+	if _, e := writer.database.Exec("SET enable_seqscan = off"); e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		network  string
+		expected []string
+	}{
+		{"192.0.2.0/24", []string{"a.example.com 192.0.2.1", "b.example.com 192.0.2.200"}},
+		{"192.0.2.128/25", []string{"b.example.com 192.0.2.200"}},
+		{"0.0.0.0/0", []string{"a.example.com 192.0.2.1", "b.example.com 192.0.2.200", "c.example.com 198.51.100.1"}},
+		{"2001:db8::/48", []string{"a.example.com 2001:db8::1"}},
+		{"2001:db8::/32", []string{"a.example.com 2001:db8::1", "d.example.com 2001:db8:1::1"}},
+		{"203.0.113.0/24", []string{}},
+	}
+	const statement = "SELECT name, data FROM records WHERE address <<= $1::inet ORDER BY name, data"
+	for _, test := range tests {
+		rows, e := writer.database.Query(statement, test.network)
+		if e != nil {
+			t.Fatal(e)
+		}
+		found := []string{}
+		for rows.Next() {
+			var name, data string
+			if e := rows.Scan(&name, &data); e != nil {
+				t.Fatal(e)
+			}
+			found = append(found, name+" "+data)
+		}
+		rows.Close()
+		if strings.Join(found, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("%v: got %v, expected %v", test.network, found, test.expected)
+		}
+	}
+
+	rows, e := writer.database.Query("EXPLAIN "+statement, "192.0.2.0/24")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer rows.Close()
+	plan := []string{}
+	for rows.Next() {
+		var line string
+		if e := rows.Scan(&line); e != nil {
+			t.Fatal(e)
+		}
+		plan = append(plan, line)
+	}
+	if !strings.Contains(strings.Join(plan, "\n"), "idx_records_address") {
+		t.Errorf("network lookup does not use the address index:\n%v", strings.Join(plan, "\n"))
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// output formats of the query subcommand
+const (
+	QUERYTABLE = "table"
+	QUERYJSON  = "json"
+	QUERYCOF   = "cof"
+)
+
+// a point in time, absolute (RFC 3339, "2006-01-02T15:04:05" or "2006-01-02",
+// local time) or relative to now (e.g. "24h" is a day ago), empty is zero
+func queryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, e := time.ParseDuration(value); e == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if seen, e := time.Parse(time.RFC3339, value); e == nil {
+		return seen, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02"} {
+		if seen, e := time.ParseInLocation(layout, value, time.Local); e == nil {
+			return seen, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time \"%v\"", value)
+}
+
+// print the records as a table, a JSON array or as COF (passive DNS common
+// output format, a JSON object per line)
+func printRecords(output io.Writer, records []Record, format string) error {
+	switch format {
+	case QUERYJSON:
+		type record struct {
+			Name      string    `json:"name"`
+			Class     string    `json:"class"`
+			Type      string    `json:"type"`
+			Data      string    `json:"data"`
+			Count     int64     `json:"count"`
+			FirstSeen time.Time `json:"first_seen"`
+			LastSeen  time.Time `json:"last_seen"`
+		}
+		result := make([]record, 0, len(records))
+		for _, r := range records {
+			result = append(result, record{r.Name, r.Class, r.Type, r.Data, r.Count, r.FirstSeen, r.LastSeen})
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case QUERYCOF:
+		type record struct {
+			Name      string `json:"rrname"`
+			Type      string `json:"rrtype"`
+			Data      string `json:"rdata"`
+			Count     int64  `json:"count"`
+			FirstSeen int64  `json:"time_first"`
+			LastSeen  int64  `json:"time_last"`
+		}
+		encoder := json.NewEncoder(output)
+		for _, r := range records {
+			if e := encoder.Encode(record{r.Name, r.Type, r.Data, r.Count, r.FirstSeen.Unix(), r.LastSeen.Unix()}); e != nil {
+				return e
+			}
+		}
+		return nil
+	default:
+		writer := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tCLASS\tTYPE\tDATA\tCOUNT\tFIRST SEEN\tLAST SEEN")
+		for _, r := range records {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", r.Name, r.Class, r.Type, r.Data, r.Count, r.FirstSeen.Format(time.RFC3339), r.LastSeen.Format(time.RFC3339))
+		}
+		return writer.Flush()
+	}
+}
+
+// print the resolutions like the records, the chain is the hops and the
+// target, empty for the addresses of the name itself
+func printResolutions(output io.Writer, resolutions []Resolution, format string) error {
+	chain := func(resolution Resolution) []string {
+		if resolution.Target == "" {
+			return []string{}
+		}
+		return append(append([]string{}, resolution.Hops...), resolution.Target)
+	}
+
+	switch format {
+	case QUERYJSON:
+		type resolution struct {
+			Name      string    `json:"name"`
+			Chain     []string  `json:"chain"`
+			Type      string    `json:"type"`
+			Data      string    `json:"data"`
+			Count     int64     `json:"count"`
+			FirstSeen time.Time `json:"first_seen"`
+			LastSeen  time.Time `json:"last_seen"`
+		}
+		result := make([]resolution, 0, len(resolutions))
+		for _, r := range resolutions {
+			result = append(result, resolution{r.Name, chain(r), r.Type, r.Data, r.Count, r.FirstSeen, r.LastSeen})
+		}
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case QUERYCOF:
+		type resolution struct {
+			Name      string   `json:"rrname"`
+			Type      string   `json:"rrtype"`
+			Data      string   `json:"rdata"`
+			Count     int64    `json:"count"`
+			FirstSeen int64    `json:"time_first"`
+			LastSeen  int64    `json:"time_last"`
+			Chain     []string `json:"cname_chain,omitempty"`
+		}
+		encoder := json.NewEncoder(output)
+		for _, r := range resolutions {
+			if e := encoder.Encode(resolution{r.Name, r.Type, r.Data, r.Count, r.FirstSeen.Unix(), r.LastSeen.Unix(), chain(r)}); e != nil {
+				return e
+			}
+		}
+		return nil
+	default:
+		writer := tabwriter.NewWriter(output, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tCHAIN\tTYPE\tDATA\tCOUNT\tFIRST SEEN\tLAST SEEN")
+		for _, r := range resolutions {
+			hops := "-"
+			if r.Target != "" {
+				hops = strings.Join(chain(r), " > ")
+			}
+			rrtype, data := r.Type, r.Data
+			if rrtype == "" {
+				// a chain to a target without records
+				rrtype, data = "-", "-"
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", r.Name, hops, rrtype, data, r.Count, r.FirstSeen.Format(time.RFC3339), r.LastSeen.Format(time.RFC3339))
+		}
+		return writer.Flush()
+	}
+}
+
+// the options selecting the store and the time range, shared by the query
+// subcommands
+type queryOptions struct {
+	sqlite    *string
+	partition *string
+	bolt      *string
+	from      *string
+	to        *string
+}
+
+func newQueryOptions(flags *flag.FlagSet) *queryOptions {
+	return &queryOptions{
+		sqlite:    flags.String("sqlite", "", "SQLite3 database to query (read only)"),
+		partition: flags.String("sqlite-partition", PARTITIONNONE, "Query the day or week partitions of the SQLite3 database [day, week]"),
+		bolt:      flags.String("bolt", "", "bbolt database to query (read only), locked while written by passivedns -bolt (query it over HTTP instead)"),
+		from:      flags.String("from", "", "Only observations since, e.g. 2026-10-16, 2026-10-16T12:00:00 or 24h (ago)"),
+		to:        flags.String("to", "", "Only observations until, e.g. 2026-10-17 or 1h (ago)")}
+}
+
+// open the store and parse the time range, exits on errors
+func (this *queryOptions) open() (Store, Query) {
+	var filter Query
+	var e error
+	if filter.From, e = queryTime(*this.from); e != nil {
+		fatalln(e)
+	}
+	if filter.To, e = queryTime(*this.to); e != nil {
+		fatalln(e)
+	}
+
+	var store Store
+	switch {
+	case *this.sqlite != "":
+		store, e = OpenSqliteStore(*this.sqlite, *this.partition)
+	case *this.bolt != "":
+		store, e = OpenBoltStore(*this.bolt)
+	default:
+		fatalln("Missing argument -sqlite <database> or -bolt <database>")
+	}
+	if e != nil {
+		fatalln(e)
+	}
+	return store, filter
+}
+
+// parse the arguments, options may also follow the positional arguments,
+// exits unless there are count positional arguments
+func queryArguments(flags *flag.FlagSet, args []string, count int) []string {
+	flags.Parse(args)
+
+	positional := flags.Args()
+	if count < len(positional) {
+		flags.Parse(positional[count:])
+		positional = append(positional[:count], flags.Args()...)
+	}
+	if len(positional) != count {
+		flags.Usage()
+		os.Exit(2)
+	}
+	return positional
+}
+
+// the records of a lookup (name, rdata, ip or suffix) of the value
+func lookupRecords(store Store, lookup string, value string, filter Query) ([]Record, error) {
+	switch lookup {
+	case "name":
+		return store.Name(value, filter)
+	case "rdata":
+		return store.Data(value, filter)
+	case "ip":
+		// an address or a network
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			return store.Network(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, filter)
+		}
+		if _, network, e := net.ParseCIDR(value); e == nil {
+			return store.Network(network, filter)
+		}
+		return nil, fmt.Errorf("invalid IP address or network \"%v\"", value)
+	case "suffix":
+		return store.Suffix(value, filter)
+	}
+	return nil, fmt.Errorf("invalid lookup \"%v\", expected name, rdata, ip, suffix or resolve", lookup)
+}
+
+// query the records of a store, "passivedns query -sqlite pdns.db name www.example.com",
+// the lookups are name (also "*.example.com" or "www.example.*"), rdata, ip
+// (also a network, e.g. "192.0.2.0/24"), suffix and resolve (the addresses of
+// the name, including through CNAME chains)
+func query(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	options := newQueryOptions(flags)
+	types := flags.String("type", "", "Only these types, e.g. A,AAAA")
+	format := flags.String("format", QUERYTABLE, "Output format [table, json, cof]")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: passivedns query [options] name|rdata|ip|suffix|resolve <value>\n")
+		fmt.Fprintf(flags.Output(), "Names may have a left-hand (*.example.com) or right-hand (www.example.*) wildcard, an escaped asterisk (\\*.example.com) is the name of a wildcard record, ip takes an address or a network (192.0.2.0/24), resolve follows CNAME chains to the addresses\n")
+		flags.PrintDefaults()
+	}
+	lookup := queryArguments(flags, args, 2)
+
+	if *format != QUERYTABLE && *format != QUERYJSON && *format != QUERYCOF {
+		fatalf("Invalid argument -format %v, expected table, json or cof\n", *format)
+	}
+
+	store, filter := options.open()
+	defer store.Close()
+	if *types != "" {
+		filter.Types = strings.Split(strings.ToUpper(*types), ",")
+	}
+
+	if lookup[0] == "resolve" {
+		resolutions, e := store.Resolve(lookup[1], filter)
+		if e != nil {
+			fatalln(e)
+		}
+		if e := printResolutions(os.Stdout, resolutions, *format); e != nil {
+			fatalln(e)
+		}
+		fmt.Fprintf(os.Stderr, "Found %v resolution(s)\n", len(resolutions))
+		return
+	}
+
+	records, e := lookupRecords(store, lookup[0], lookup[1], filter)
+	if e != nil {
+		fatalln(e)
+	}
+
+	if e := printRecords(os.Stdout, records, *format); e != nil {
+		fatalln(e)
+	}
+	fmt.Fprintf(os.Stderr, "Found %v record(s)\n", len(records))
+}
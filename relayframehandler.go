@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	dnstapserver "passivedns/dnstapserver"
+	"time"
+
+	framestream "github.com/farsightsec/golang-framestream"
+)
+
+// timeout for connecting to, and writing frames to, a downstream endpoint
+const RELAYTIMEOUT = 15 * time.Second
+
+// upper limit for the reconnect backoff
+const RELAYMAXBACKOFF = time.Minute
+
+// relayConnection writes frames to a downstream Frame Streams reader
+type relayConnection struct {
+	connection net.Conn
+	writer     *framestream.Writer
+}
+
+func (this *relayConnection) write(frame []byte) error {
+	_, e := this.writer.WriteFrame(frame)
+	return e
+}
+
+func (this *relayConnection) flush() error {
+	return this.writer.Flush()
+}
+
+func (this *relayConnection) finish() {
+	this.writer.Close()
+}
+
+func (this *relayConnection) close() {
+	this.connection.Close()
+}
+
+// relayFrameHandler forwards the raw Dnstap frames to a downstream Frame
+// Streams reader (see forwarder).
+type relayFrameHandler struct {
+	forwarder *forwarder
+}
+
+func (this *relayFrameHandler) HandleFrame(frame []byte) {
+	this.forwarder.send(frame)
+}
+
+func (this *relayFrameHandler) Close() {
+	this.forwarder.close()
+}
+
+func NewRelayFrameHandler(network string, address string, buffer int) dnstapserver.DnstapFrameHandler {
+	fmt.Fprintf(os.Stderr, "Creating Dnstap relay frame handler \"%v:%v\"\n", network, address)
+
+	connect := func() (forwarderConnection, string, error) {
+		connection, e := net.DialTimeout(network, address, RELAYTIMEOUT)
+		if e != nil {
+			return nil, network, e
+		}
+
+		writer, e := framestream.NewWriter(connection, &framestream.WriterOptions{ContentTypes: [][]byte{[]byte(dnstapserver.CONTENT_TYPE_PROTOBUF_DNSTAP)}, Bidirectional: true, Timeout: RELAYTIMEOUT})
+		if e != nil {
+			connection.Close()
+			return nil, network, e
+		}
+		return &relayConnection{connection: connection, writer: writer}, network, nil
+	}
+
+	return &relayFrameHandler{forwarder: newForwarder("Relay", "frame", network, address, buffer, connect)}
+}
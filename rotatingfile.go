@@ -0,0 +1,205 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timestamp layout used in the names of rotated files, sorts chronologically
+const ROTATEDTIMEFORMAT = "20060102T150405.000000"
+
+// name of a file once rotated, e.g. "pdns-20261016T170000.000000.csv" for
+// "pdns.csv" opened at 17:00
+func rotatedPath(path string, opened time.Time) string {
+	extension := filepath.Ext(path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, extension), opened.Format(ROTATEDTIMEFORMAT), extension)
+}
+
+// rotatingFile is a file (e.g. a capture or CSV file) that is moved aside when
+// it exceeds a size and/or an age, and opened again by the next write. Rotated
+// files are optionally compressed (gzip) and only the most recent ones are
+// retained, in the background so the writer does not wait for it. It is not
+// safe for concurrent use.
+type rotatingFile struct {
+	name     string
+	path     string
+	size     int64
+	interval time.Duration
+	compress bool
+	retain   int
+
+	file    *os.File
+	written int64
+	opened  time.Time
+
+	rotations chan struct{}
+	archived  chan struct{}
+}
+
+// open the file, it must not exist (anymore)
+func (this *rotatingFile) open() error {
+	file, e := os.OpenFile(this.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if e != nil {
+		return e
+	}
+	this.file, this.written, this.opened = file, 0, time.Now()
+	return nil
+}
+
+func (this *rotatingFile) Write(data []byte) (int, error) {
+	if this.file == nil {
+		return 0, fmt.Errorf("%v file \"%v\" is not open", this.name, this.path)
+	}
+	n, e := this.file.Write(data)
+	this.written += int64(n)
+	return n, e
+}
+
+// whether the file exceeds the size or the age
+func (this *rotatingFile) expired() bool {
+	if this.file == nil {
+		return false
+	}
+	return (0 < this.size && this.size <= this.written) || (0 < this.interval && this.interval <= time.Since(this.opened))
+}
+
+// close the file and move it aside, the writer must be flushed
+func (this *rotatingFile) rotate() {
+	if this.file == nil {
+		return
+	}
+
+	if e := this.file.Close(); e != nil {
+		fmt.Fprintf(os.Stderr, "Failed to close %v file \"%v\": %v\n", this.name, this.path, e)
+	}
+	this.file = nil
+
+	if e := os.Rename(this.path, rotatedPath(this.path, this.opened)); e != nil {
+		fmt.Fprintf(os.Stderr, "Failed to rotate %v file \"%v\": %v\n", this.name, this.path, e)
+		return
+	}
+	this.archive()
+}
+
+// wake the archiver, which handles all rotated files at once, without waiting
+// for it
+func (this *rotatingFile) archive() {
+	select {
+	case this.rotations <- struct{}{}:
+	default:
+	}
+}
+
+// close the file (without moving it aside) and wait for the archiver
+func (this *rotatingFile) close() {
+	if this.file != nil {
+		if e := this.file.Close(); e != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close %v file \"%v\": %v\n", this.name, this.path, e)
+		}
+		this.file = nil
+	}
+	close(this.rotations)
+	<-this.archived
+}
+
+// the rotated files, oldest first, without those still being compressed
+func (this *rotatingFile) rotated() []string {
+	extension := filepath.Ext(this.path)
+	files, e := filepath.Glob(strings.TrimSuffix(this.path, extension) + "-*" + extension + "*")
+	if e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		return nil
+	}
+
+	rotated := make([]string, 0, len(files))
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".gz.tmp") {
+			rotated = append(rotated, file)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated
+}
+
+// compress (optionally) and prune the rotated files
+func (this *rotatingFile) archiver() {
+	defer close(this.archived)
+
+	for range this.rotations {
+		if this.compress {
+			for _, file := range this.rotated() {
+				if !strings.HasSuffix(file, ".gz") {
+					if e := gzipFile(file); e != nil {
+						fmt.Fprintf(os.Stderr, "Failed to compress %v file \"%v\": %v\n", this.name, file, e)
+					}
+				}
+			}
+		}
+
+		// remove the oldest rotated files exceeding the retention count
+		if 0 < this.retain {
+			rotated := this.rotated()
+			for i := 0; i < len(rotated)-this.retain; i++ {
+				if e := os.Remove(rotated[i]); e != nil {
+					fmt.Fprintln(os.Stderr, e)
+				}
+			}
+		}
+	}
+}
+
+func gzipFile(file string) error {
+	input, e := os.Open(file)
+	if e != nil {
+		return e
+	}
+	defer input.Close()
+
+	output, e := os.Create(file + ".gz.tmp")
+	if e != nil {
+		return e
+	}
+
+	compressor := gzip.NewWriter(output)
+	if _, e := io.Copy(compressor, input); e != nil {
+		output.Close()
+		os.Remove(output.Name())
+		return e
+	}
+	if e := compressor.Close(); e != nil {
+		output.Close()
+		os.Remove(output.Name())
+		return e
+	}
+	if e := output.Close(); e != nil {
+		os.Remove(output.Name())
+		return e
+	}
+
+	if e := os.Rename(output.Name(), file+".gz"); e != nil {
+		return e
+	}
+	return os.Remove(file)
+}
+
+// create a rotating file, name is the kind of file in messages (e.g. "CSV").
+// A file left behind by a previous run is moved aside.
+func newRotatingFile(name string, path string, size int64, interval time.Duration, compress bool, retain int) (*rotatingFile, error) {
+	this := &rotatingFile{name: name, path: path, size: size, interval: interval, compress: compress, retain: retain, rotations: make(chan struct{}, 1), archived: make(chan struct{})}
+
+	if fstat, e := os.Stat(path); e == nil {
+		if e := os.Rename(path, rotatedPath(path, fstat.ModTime())); e != nil {
+			return nil, e
+		}
+		this.archive()
+	}
+	go this.archiver()
+
+	return this, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pdns.csv")
+	// left behind by a previous run
+	if e := os.WriteFile(path, []byte("left behind\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	file, e := newRotatingFile("test", path, 16, 0, true, 2)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 5; i++ {
+		if e := file.open(); e != nil {
+			t.Fatal(e)
+		}
+		for !file.expired() {
+			file.Write([]byte("0123456789\n"))
+		}
+		file.rotate()
+		// distinct names
+		time.Sleep(time.Millisecond)
+	}
+	if e := file.open(); e != nil {
+		t.Fatal(e)
+	}
+	file.Write([]byte("current\n"))
+	file.close()
+
+	rotated := file.rotated()
+	if len(rotated) != 2 {
+		t.Fatalf("got rotated files %v, expected 2", rotated)
+	}
+	for _, file := range rotated {
+		if !strings.HasSuffix(file, ".csv.gz") {
+			t.Errorf("got %v, expected compressed files", file)
+		}
+	}
+	if data, e := os.ReadFile(path); e != nil || string(data) != "current\n" {
+		t.Errorf("got %q (%v), expected the current file", data, e)
+	}
+}
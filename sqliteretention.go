@@ -0,0 +1,264 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// number of rows deleted per transaction, small enough to not hold the write lock for long
+const RETENTIONBATCH = 5000
+
+// number of pages returned to the file system per incremental vacuum
+const RETENTIONVACUUM = 1024
+
+// sqliteRetention periodically deletes the answers (observations) older than
+// the retention period of their type, a record observed since keeps its recent
+// observations, and returns the freed pages to the file system (incremental
+// vacuum). The work is done in small batches so the message handlers can keep
+// inserting in between.
+type sqliteRetention struct {
+	database  string
+	partition string
+	period    time.Duration
+	periods   map[string]time.Duration
+	interval  time.Duration
+}
+
+// delete the rows of the table matching the condition in batches, returns the number of deleted rows
+func (this *sqliteRetention) delete(db *sql.DB, table string, condition string, arguments ...interface{}) (int64, error) {
+	statement := fmt.Sprintf("DELETE FROM %s WHERE rowid IN (SELECT rowid FROM %s WHERE %s LIMIT %d)", table, table, condition, RETENTIONBATCH)
+
+	var deleted int64
+	for {
+		result, e := db.Exec(statement, arguments...)
+		if e != nil {
+			return deleted, e
+		}
+
+		count, e := result.RowsAffected()
+		if e != nil {
+			return deleted, e
+		}
+		deleted += count
+
+		if count < RETENTIONBATCH {
+			return deleted, nil
+		}
+
+		// let the writers in
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// return the free pages to the file system, only databases in incremental
+// auto vacuum mode can (others reuse them)
+func (this *sqliteRetention) vacuum(db *sql.DB) error {
+	var mode int
+	if e := db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); e != nil {
+		return e
+	}
+	if mode != 2 {
+		return nil
+	}
+
+	previous := int64(-1)
+	for {
+		var free int64
+		if e := db.QueryRow("PRAGMA freelist_count").Scan(&free); e != nil {
+			return e
+		}
+		// done, or no progress (e.g. pages freed by the writers in between)
+		if free == 0 || (0 <= previous && previous <= free) {
+			return nil
+		}
+		previous = free
+
+		// the pragma frees a page per step, exhaust it
+		rows, e := db.Query(fmt.Sprintf("PRAGMA incremental_vacuum(%d)", RETENTIONVACUUM))
+		if e != nil {
+			return e
+		}
+		for rows.Next() {
+		}
+		if e := rows.Close(); e != nil {
+			return e
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// a partition can be removed as a whole when all of its answers have expired
+func (this *sqliteRetention) expired(file string, now time.Time) bool {
+	start, ok := partitionParse(this.database, this.partition, file)
+	if !ok || this.period == 0 {
+		return false
+	}
+
+	longest := this.period
+	for _, period := range this.periods {
+		if period == 0 {
+			return false
+		}
+		if longest < period {
+			longest = period
+		}
+	}
+
+	return partitionEnd(this.partition, start).Before(now.Add(-longest))
+}
+
+func (this *sqliteRetention) prune() error {
+	files, e := partitionFiles(this.database, this.partition, time.Time{}, time.Time{})
+	if e != nil {
+		return e
+	}
+
+	now := time.Now()
+	for _, file := range files {
+		if this.expired(file, now) {
+			fmt.Fprintf(os.Stderr, "SQLite3 retention removing expired partition \"%v\"\n", file)
+			// and the write ahead log and its index
+			for _, name := range []string{file, file + "-wal", file + "-shm"} {
+				if e := os.Remove(name); e != nil && !os.IsNotExist(e) {
+					return e
+				}
+			}
+			continue
+		}
+
+		db, e := sql.Open("sqlite3", dsn(file, "_busy_timeout=10000"))
+		if e != nil {
+			return e
+		}
+		e = this.pruneDatabase(db, file, now)
+		db.Close()
+		if e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (this *sqliteRetention) pruneDatabase(db *sql.DB, file string, now time.Time) error {
+	deleted := int64(0)
+
+	// types with their own retention period
+	types := make([]string, 0, len(this.periods))
+	for rrtype, period := range this.periods {
+		types = append(types, rrtype)
+		if 0 < period {
+			count, e := this.delete(db, "answers", "type = ? AND time < ?", rrtype, now.Add(-period).Format(SQLITETIMEFORMAT))
+			deleted += count
+			if e != nil {
+				return e
+			}
+		}
+	}
+
+	// all other types
+	if 0 < this.period {
+		condition, arguments := "time < ?", make([]interface{}, 0, len(types)+1)
+		if 0 < len(types) {
+			condition = "type NOT IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(types)), ", ") + ") AND " + condition
+			for _, rrtype := range types {
+				arguments = append(arguments, rrtype)
+			}
+		}
+		arguments = append(arguments, now.Add(-this.period).Format(SQLITETIMEFORMAT))
+
+		count, e := this.delete(db, "answers", condition, arguments...)
+		deleted += count
+		if e != nil {
+			return e
+		}
+	}
+
+	// the CNAME chains expire with the CNAME answers (databases written before chains were recorded have none)
+	period, ok := this.periods["CNAME"]
+	if !ok {
+		period = this.period
+	}
+	if 0 < period {
+		var count int
+		if e := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'chains'").Scan(&count); e != nil {
+			return e
+		}
+		if 0 < count {
+			if _, e := this.delete(db, "chains", "time < ?", now.Add(-period).Format(SQLITETIMEFORMAT)); e != nil {
+				return e
+			}
+		}
+	}
+
+	if 0 < deleted {
+		fmt.Fprintf(os.Stderr, "SQLite3 retention deleted %v answer(s) from \"%v\" in %v\n", deleted, file, time.Since(now).Round(time.Millisecond))
+		return this.vacuum(db)
+	}
+	return nil
+}
+
+func (this *sqliteRetention) run() {
+	for {
+		if e := this.prune(); e != nil {
+			fmt.Fprintf(os.Stderr, "SQLite3 retention on \"%v\" failed: %v\n", this.database, e)
+		}
+		time.Sleep(this.interval)
+	}
+}
+
+// parse per type retention periods given in days, e.g. "A=30,CNAME=90"
+func retentionPeriods(value string) (map[string]time.Duration, error) {
+	periods := make(map[string]time.Duration)
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+
+		pair := strings.SplitN(field, "=", 2)
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("invalid retention \"%v\", expected <type>=<days>", field)
+		}
+
+		rrtype := strings.ToUpper(strings.TrimSpace(pair[0]))
+		if _, ok := dns.StringToType[rrtype]; !ok {
+			return nil, fmt.Errorf("invalid retention \"%v\", unknown type \"%v\"", field, pair[0])
+		}
+
+		days, e := strconv.Atoi(strings.TrimSpace(pair[1]))
+		if e != nil || days < 0 {
+			return nil, fmt.Errorf("invalid retention \"%v\", expected a number of days", field)
+		}
+
+		periods[rrtype] = time.Duration(days) * 24 * time.Hour
+	}
+	return periods, nil
+}
+
+// StartSqliteRetention starts pruning the database (or its partitions) in the
+// background, a zero period keeps the answers (of the types without a period
+// of their own) forever.
+func StartSqliteRetention(database string, partition string, period time.Duration, periods map[string]time.Duration, interval time.Duration) {
+	fmt.Fprintf(os.Stderr, "Starting SQLite3 retention on \"%v\"\n", database)
+
+	if partition == PARTITIONNONE {
+		if db, e := sql.Open("sqlite3", database); e == nil {
+			var mode int
+			if e := db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); e == nil && mode != 2 {
+				fmt.Fprintf(os.Stderr, "SQLite3 database \"%v\" is not in incremental auto vacuum mode, freed pages are reused but not returned to the file system (run \"PRAGMA auto_vacuum = INCREMENTAL; VACUUM;\" once to convert it)\n", database)
+			}
+			db.Close()
+		} else {
+			fatalln(e)
+		}
+	}
+
+	retention := &sqliteRetention{database: database, partition: partition, period: period, periods: periods, interval: interval}
+	go retention.run()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// answers are deleted by observation time in batches, per type, and the freed
+// pages are returned to the file system
+func TestSqliteRetention(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "pdns.db")
+	db := sqliteCreate(database)
+
+	now := time.Now()
+	old, recent := now.Add(-10*24*time.Hour), now.Add(-time.Hour)
+	answers := []Answer{
+		// observed before and within the period
+		{Time: old, Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Data: "192.0.2.1"},
+		{Time: recent, Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Data: "192.0.2.1"},
+		// observed before only
+		{Time: old, Name: "old.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Data: "192.0.2.2"},
+	}
+	// more than a batch of a type with a shorter period, on many pages
+	padding := strings.Repeat("x", 200)
+	for i := 0; i < 2*RETENTIONBATCH+10; i++ {
+		answers = append(answers, Answer{Time: old.Add(time.Duration(i) * time.Millisecond), Name: fmt.Sprintf("t%d.example.com.", i), Class: dns.ClassINET, Type: dns.TypeTXT, Data: padding})
+	}
+	answers = append(answers, Answer{Time: recent, Name: "t.example.com.", Class: dns.ClassINET, Type: dns.TypeTXT, Data: padding})
+	if e := sqliteInsert(db, answers); e != nil {
+		t.Fatal(e)
+	}
+	db.Close()
+
+	pages := func() int64 {
+		db, e := sql.Open("sqlite3", database)
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer db.Close()
+		var count int64
+		if e := db.QueryRow("PRAGMA page_count").Scan(&count); e != nil {
+			t.Fatal(e)
+		}
+		return count
+	}
+	before := pages()
+
+	retention := &sqliteRetention{database: database, partition: PARTITIONNONE, period: 30 * 24 * time.Hour, periods: map[string]time.Duration{"TXT": 7 * 24 * time.Hour}}
+	if e := retention.prune(); e != nil {
+		t.Fatal(e)
+	}
+
+	store, e := OpenSqliteStore(database, PARTITIONNONE)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer store.Close()
+	records, e := store.Suffix("example.com", Query{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	found := []string{}
+	for _, record := range records {
+		found = append(found, fmt.Sprintf("%v %v %v", record.Name, record.Type, record.Count))
+	}
+	if expected := []string{"old.example.com A 1", "t.example.com TXT 1", "www.example.com A 2"}; fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Errorf("got %q, expected %q", found, expected)
+	}
+
+	// a second run with a period of a day keeps the recent observations only
+	retention.period = 24 * time.Hour
+	if e := retention.prune(); e != nil {
+		t.Fatal(e)
+	}
+	records, e = store.Name("www.example.com", Query{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(records) != 1 || records[0].Count != 1 || !records[0].FirstSeen.Equal(recent.Truncate(time.Microsecond)) {
+		t.Errorf("got %v, expected the observation within the period", records)
+	}
+	if records, e := store.Name("old.example.com", Query{}); e != nil || len(records) != 0 {
+		t.Errorf("got %v (%v), expected the record to be deleted", records, e)
+	}
+
+	if after := pages(); before/2 < after {
+		t.Errorf("got %v page(s), expected less than half of %v", after, before)
+	}
+}
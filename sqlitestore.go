@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// partitioning of the SQLite store
+const (
+	PARTITIONNONE = ""
+	PARTITIONDAY  = "day"
+	PARTITIONWEEK = "week"
+)
+
+// SQLite limits the number of attached databases (SQLITE_MAX_ATTACHED)
+const MAXATTACHED = 10
+
+// start of the partition (local midnight, Monday for weeks) containing the time
+func partitionStart(partition string, seen time.Time) time.Time {
+	year, month, day := seen.Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, seen.Location())
+	if partition == PARTITIONWEEK {
+		// ISO weeks start on Monday
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+	return start
+}
+
+func partitionEnd(partition string, start time.Time) time.Time {
+	if partition == PARTITIONWEEK {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// name of the partition file holding the answers seen at the given time
+func partitionFile(database string, partition string, seen time.Time) string {
+	extension := filepath.Ext(database)
+	base := strings.TrimSuffix(database, extension)
+
+	switch partition {
+	case PARTITIONDAY:
+		return fmt.Sprintf("%s-%s%s", base, seen.Format("2006-01-02"), extension)
+	case PARTITIONWEEK:
+		year, week := seen.ISOWeek()
+		return fmt.Sprintf("%s-%04d-W%02d%s", base, year, week, extension)
+	default:
+		return database
+	}
+}
+
+// start of the partition stored in the file, false if the file is not a partition
+func partitionParse(database string, partition string, file string) (time.Time, bool) {
+	extension := filepath.Ext(database)
+	base := strings.TrimSuffix(database, extension) + "-"
+	if !strings.HasPrefix(file, base) || !strings.HasSuffix(file, extension) {
+		return time.Time{}, false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(file, base), extension)
+
+	switch partition {
+	case PARTITIONDAY:
+		if start, e := time.ParseInLocation("2006-01-02", name, time.Local); e == nil {
+			return start, true
+		}
+	case PARTITIONWEEK:
+		var year, week int
+		if n, e := fmt.Sscanf(name, "%04d-W%02d", &year, &week); e == nil && n == 2 && 1 <= week && week <= 53 {
+			// January 4th is always in ISO week 1
+			start := partitionStart(PARTITIONWEEK, time.Date(year, time.January, 4, 0, 0, 0, 0, time.Local))
+			return start.AddDate(0, 0, 7*(week-1)), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// the existing partition files (oldest first) holding answers seen within
+// the time range, a zero from or to leaves that end open
+func partitionFiles(database string, partition string, from time.Time, to time.Time) ([]string, error) {
+	if partition == PARTITIONNONE {
+		if _, e := os.Stat(database); e != nil {
+			return nil, e
+		}
+		return []string{database}, nil
+	}
+
+	extension := filepath.Ext(database)
+	candidates, e := filepath.Glob(strings.TrimSuffix(database, extension) + "-*" + extension)
+	if e != nil {
+		return nil, e
+	}
+
+	files := []string{}
+	for _, file := range candidates {
+		if start, ok := partitionParse(database, partition, file); ok {
+			if !to.IsZero() && to.Before(start) {
+				continue
+			}
+			if !from.IsZero() && !from.Before(partitionEnd(partition, start)) {
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// sqliteStore queries a SQLite database, or the partitions of one, read only.
+// Partitions are attached to an in-memory database and queried as a union.
+type sqliteStore struct {
+	database  string
+	partition string
+}
+
+func (this *sqliteStore) Close() error {
+	return nil
+}
+
+// SQL condition and arguments for the time range and types of the query
+func (this *sqliteStore) where(condition string, arguments []interface{}, query Query) (string, []interface{}) {
+	if !query.From.IsZero() {
+		condition += " AND time >= ?"
+		arguments = append(arguments, query.From.Local().Format(SQLITETIMEFORMAT))
+	}
+	if !query.To.IsZero() {
+		condition += " AND time <= ?"
+		arguments = append(arguments, query.To.Local().Format(SQLITETIMEFORMAT))
+	}
+	if 0 < len(query.Types) {
+		condition += " AND type IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(query.Types)), ", ") + ")"
+		for _, rrtype := range query.Types {
+			arguments = append(arguments, strings.ToUpper(rrtype))
+		}
+	}
+	return condition, arguments
+}
+
+// the partition files of the time range of the query, in chunks small enough to be attached at once
+func (this *sqliteStore) chunks(query Query) ([][]string, error) {
+	files, e := partitionFiles(this.database, this.partition, query.From, query.To)
+	if e != nil {
+		return nil, e
+	}
+
+	chunks := [][]string{}
+	for 0 < len(files) {
+		chunk := files
+		if MAXATTACHED < len(chunk) {
+			chunk = chunk[:MAXATTACHED]
+		}
+		files = files[len(chunk):]
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// attach the files as p0, p1, ... to an in-memory database, close the database when done
+func (this *sqliteStore) attach(files []string) (*sql.DB, *sql.Conn, error) {
+	db, e := sql.Open(SQLITEDRIVER, ":memory:?_query_only=1")
+	if e != nil {
+		return nil, nil, e
+	}
+
+	// attached databases are per connection
+	connection, e := db.Conn(context.Background())
+	if e != nil {
+		db.Close()
+		return nil, nil, e
+	}
+
+	for i, file := range files {
+		if _, e := connection.ExecContext(context.Background(), fmt.Sprintf("ATTACH DATABASE ? AS p%d", i), file); e != nil {
+			connection.Close()
+			db.Close()
+			return nil, nil, fmt.Errorf("failed to attach \"%v\": %v", file, e)
+		}
+	}
+	return db, connection, nil
+}
+
+// run the aggregating query against each chunk of the files
+func (this *sqliteStore) lookup(condition string, arguments []interface{}, query Query) ([]Record, error) {
+	chunks, e := this.chunks(query)
+	if e != nil {
+		return nil, e
+	}
+
+	condition, arguments = this.where(condition, arguments, query)
+
+	records := []Record{}
+	for _, chunk := range chunks {
+		found, e := this.attached(chunk, condition, arguments)
+		if e != nil {
+			return nil, e
+		}
+		records = append(records, found...)
+	}
+
+	return merge(records), nil
+}
+
+func (this *sqliteStore) attached(files []string, condition string, arguments []interface{}) ([]Record, error) {
+	db, connection, e := this.attach(files)
+	if e != nil {
+		return nil, e
+	}
+	defer db.Close()
+	defer connection.Close()
+
+	selects := make([]string, 0, len(files))
+	parameters := make([]interface{}, 0, len(files)*len(arguments))
+	for i := range files {
+		// partitions created before columns were added lack them (until written to again)
+		source, missing := fmt.Sprintf("p%d.answers", i), false
+		columns := []string{"name", "class", "type", "data", "time"}
+		for _, column := range SQLITEADDED {
+			var count int
+			if e := connection.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM pragma_table_info('answers', ?) WHERE name = ?", fmt.Sprintf("p%d", i), column.name).Scan(&count); e != nil {
+				return nil, e
+			}
+			if count == 0 {
+				columns, missing = append(columns, column.value+" AS "+column.name), true
+			} else {
+				columns = append(columns, column.name)
+			}
+		}
+		if missing {
+			source = fmt.Sprintf("(SELECT %s FROM %s)", strings.Join(columns, ", "), source)
+		}
+
+		selects = append(selects, fmt.Sprintf("SELECT name, class, type, data, time FROM %s WHERE %s", source, condition))
+		parameters = append(parameters, arguments...)
+	}
+
+	rows, e := connection.QueryContext(context.Background(), "SELECT name, class, type, data, COUNT(*), MIN(time), MAX(time) FROM ("+strings.Join(selects, " UNION ALL ")+") GROUP BY name, class, type, data", parameters...)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		var record Record
+		var first, last string
+		if e := rows.Scan(&record.Name, &record.Class, &record.Type, &record.Data, &record.Count, &first, &last); e != nil {
+			return nil, e
+		}
+		record.FirstSeen, _ = time.ParseInLocation(SQLITETIMEFORMAT, first, time.Local)
+		record.LastSeen, _ = time.ParseInLocation(SQLITETIMEFORMAT, last, time.Local)
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (this *sqliteStore) Name(name string, query Query) ([]Record, error) {
+	kind, name, e := namePattern(name)
+	if e != nil {
+		return nil, e
+	}
+
+	switch {
+	case kind == NAMEBELOW && name == "":
+		return this.lookup("1 = 1", []interface{}{}, query)
+	case kind == NAMEBELOW:
+		low, high := nameRange(reverse(name))
+		return this.lookup("rname >= ? AND rname < ?", []interface{}{low, high}, query)
+	case kind == NAMEPREFIX:
+		low, high := nameRange(name)
+		return this.lookup("name COLLATE NOCASE >= ? AND name COLLATE NOCASE < ?", []interface{}{low, high}, query)
+	default:
+		return this.lookup("rname = ?", []interface{}{reverse(name)}, query)
+	}
+}
+
+func (this *sqliteStore) Data(data string, query Query) ([]Record, error) {
+	return this.lookup("data = ?", []interface{}{strings.TrimRight(data, ".")}, query)
+}
+
+func (this *sqliteStore) Suffix(suffix string, query Query) ([]Record, error) {
+	suffix = strings.TrimRight(suffix, ".")
+	if suffix == "" {
+		// the root, all names
+		return this.lookup("1 = 1", []interface{}{}, query)
+	}
+	low, high := nameRange(reverse(suffix))
+	return this.lookup("(rname = ? OR (rname >= ? AND rname < ?))", []interface{}{reverse(suffix), low, high}, query)
+}
+
+func (this *sqliteStore) Network(network *net.IPNet, query Query) ([]Record, error) {
+	first, last := networkRange(network)
+	return this.lookup("address >= ? AND address <= ?", []interface{}{first, last}, query)
+}
+
+// the name resolves to its own records and to those of the targets of the
+// chains recorded for the responses to it
+func (this *sqliteStore) Resolve(name string, query Query) ([]Resolution, error) {
+	name = strings.TrimRight(name, ".")
+	types := resolveTypes(query)
+
+	resolutions := []Resolution{}
+	records, e := this.Name(name, Query{From: query.From, To: query.To, Types: types})
+	if e != nil {
+		return nil, e
+	}
+	for _, record := range records {
+		resolutions = append(resolutions, Resolution{Name: name, Hops: []string{}, Type: record.Type, Data: record.Data, Count: record.Count, FirstSeen: record.FirstSeen, LastSeen: record.LastSeen})
+	}
+
+	// the answers of the target in the same response as the chain
+	condition, arguments := "c.name = ? COLLATE NOCASE", []interface{}{name}
+	if !query.From.IsZero() {
+		condition += " AND c.time >= ?"
+		arguments = append(arguments, query.From.Local().Format(SQLITETIMEFORMAT))
+	}
+	if !query.To.IsZero() {
+		condition += " AND c.time <= ?"
+		arguments = append(arguments, query.To.Local().Format(SQLITETIMEFORMAT))
+	}
+	join := "a.time = c.time AND a.id = c.id AND a.name = c.target COLLATE NOCASE AND a.type IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(types)), ", ") + ")"
+	joined := make([]interface{}, 0, len(types)+len(arguments))
+	for _, rrtype := range types {
+		joined = append(joined, strings.ToUpper(rrtype))
+	}
+	joined = append(joined, arguments...)
+
+	chunks, e := this.chunks(query)
+	if e != nil {
+		return nil, e
+	}
+	for _, chunk := range chunks {
+		found, e := this.attachedChains(chunk, name, join, condition, joined)
+		if e != nil {
+			return nil, e
+		}
+		resolutions = append(resolutions, found...)
+	}
+
+	return mergeResolutions(resolutions), nil
+}
+
+func (this *sqliteStore) attachedChains(files []string, name string, join string, condition string, arguments []interface{}) ([]Resolution, error) {
+	db, connection, e := this.attach(files)
+	if e != nil {
+		return nil, e
+	}
+	defer db.Close()
+	defer connection.Close()
+
+	selects := make([]string, 0, len(files))
+	parameters := make([]interface{}, 0, len(files)*len(arguments))
+	for i := range files {
+		// partitions created before chains were recorded have none
+		var count int
+		if e := connection.QueryRowContext(context.Background(), fmt.Sprintf("SELECT COUNT(*) FROM p%d.sqlite_master WHERE type = 'table' AND name = 'chains'", i)).Scan(&count); e != nil {
+			return nil, e
+		}
+		if count == 0 {
+			continue
+		}
+
+		selects = append(selects, fmt.Sprintf("SELECT c.hops AS hops, c.target AS target, COALESCE(a.type, '') AS type, COALESCE(a.data, '') AS data, c.time AS time FROM p%d.chains c LEFT JOIN p%d.answers a ON %s WHERE %s", i, i, join, condition))
+		parameters = append(parameters, arguments...)
+	}
+	if len(selects) == 0 {
+		return []Resolution{}, nil
+	}
+
+	rows, e := connection.QueryContext(context.Background(), "SELECT hops, target, type, data, COUNT(*), MIN(time), MAX(time) FROM ("+strings.Join(selects, " UNION ALL ")+") GROUP BY hops, target, type, data", parameters...)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	resolutions := []Resolution{}
+	for rows.Next() {
+		resolution := Resolution{Name: name}
+		var hops, first, last string
+		if e := rows.Scan(&hops, &resolution.Target, &resolution.Type, &resolution.Data, &resolution.Count, &first, &last); e != nil {
+			return nil, e
+		}
+		if e := json.Unmarshal([]byte(hops), &resolution.Hops); e != nil {
+			return nil, fmt.Errorf("invalid hops \"%v\": %v", hops, e)
+		}
+		resolution.FirstSeen, _ = time.ParseInLocation(SQLITETIMEFORMAT, first, time.Local)
+		resolution.LastSeen, _ = time.ParseInLocation(SQLITETIMEFORMAT, last, time.Local)
+		resolutions = append(resolutions, resolution)
+	}
+	return resolutions, rows.Err()
+}
+
+// OpenSqliteStore opens the (optionally partitioned) database for queries.
+func OpenSqliteStore(database string, partition string) (Store, error) {
+	if partition != PARTITIONNONE && partition != PARTITIONDAY && partition != PARTITIONWEEK {
+		return nil, fmt.Errorf("invalid partitioning \"%v\", expected day or week", partition)
+	}
+	return &sqliteStore{database: database, partition: partition}, nil
+}
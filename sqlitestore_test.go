@@ -0,0 +1,130 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPartitionFile(t *testing.T) {
+	local := func(year int, month time.Month, day int, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		partition string
+		seen      time.Time
+		file      string
+		start     time.Time
+	}{
+		{PARTITIONNONE, local(2021, time.June, 1, 12), "pdns.db", time.Time{}},
+		{PARTITIONDAY, local(2021, time.June, 1, 12), "pdns-2021-06-01.db", local(2021, time.June, 1, 0)},
+		{PARTITIONDAY, local(2020, time.December, 31, 23), "pdns-2020-12-31.db", local(2020, time.December, 31, 0)},
+		{PARTITIONDAY, local(2021, time.January, 1, 0), "pdns-2021-01-01.db", local(2021, time.January, 1, 0)},
+		// Tuesday of ISO week 22
+		{PARTITIONWEEK, local(2021, time.June, 1, 12), "pdns-2021-W22.db", local(2021, time.May, 31, 0)},
+		// the weekend is the end of the week
+		{PARTITIONWEEK, local(2021, time.June, 6, 23), "pdns-2021-W22.db", local(2021, time.May, 31, 0)},
+		// 2020 has 53 ISO weeks, the first days of 2021 are in its last
+		{PARTITIONWEEK, local(2020, time.December, 28, 0), "pdns-2020-W53.db", local(2020, time.December, 28, 0)},
+		{PARTITIONWEEK, local(2021, time.January, 3, 23), "pdns-2020-W53.db", local(2020, time.December, 28, 0)},
+		{PARTITIONWEEK, local(2021, time.January, 4, 0), "pdns-2021-W01.db", local(2021, time.January, 4, 0)},
+		// the last days of 2024 are in the first ISO week of 2025
+		{PARTITIONWEEK, local(2024, time.December, 30, 12), "pdns-2025-W01.db", local(2024, time.December, 30, 0)},
+		{PARTITIONWEEK, local(2024, time.December, 29, 12), "pdns-2024-W52.db", local(2024, time.December, 23, 0)},
+	}
+	for _, test := range tests {
+		file := partitionFile("pdns.db", test.partition, test.seen)
+		if file != test.file {
+			t.Errorf("%q %v: got %v, expected %v", test.partition, test.seen, file, test.file)
+		}
+		if start := partitionStart(test.partition, test.seen); test.partition != PARTITIONNONE && !start.Equal(test.start) {
+			t.Errorf("%q %v: got the start %v, expected %v", test.partition, test.seen, start, test.start)
+		}
+		start, ok := partitionParse("pdns.db", test.partition, file)
+		if ok != (test.partition != PARTITIONNONE) || !start.Equal(test.start) {
+			t.Errorf("%q %v: parsed %v (%v), expected %v", test.partition, file, start, ok, test.start)
+		}
+	}
+}
+
+func TestPartitionParse(t *testing.T) {
+	tests := []struct {
+		partition string
+		file      string
+		ok        bool
+	}{
+		{PARTITIONDAY, "pdns-2021-06-01.db", true},
+		{PARTITIONDAY, "pdns-2021-13-01.db", false},
+		{PARTITIONDAY, "pdns-2021-W22.db", false},
+		{PARTITIONDAY, "other-2021-06-01.db", false},
+		{PARTITIONDAY, "pdns-2021-06-01.db-wal", false},
+		{PARTITIONWEEK, "pdns-2021-W22.db", true},
+		{PARTITIONWEEK, "pdns-2020-W53.db", true},
+		{PARTITIONWEEK, "pdns-2021-W00.db", false},
+		{PARTITIONWEEK, "pdns-2021-W54.db", false},
+		{PARTITIONWEEK, "pdns-2021-06-01.db", false},
+	}
+	for _, test := range tests {
+		if _, ok := partitionParse("pdns.db", test.partition, test.file); ok != test.ok {
+			t.Errorf("%q %v: got %v, expected %v", test.partition, test.file, ok, test.ok)
+		}
+	}
+}
+
+// a record seen on more days (across the year) than partitions can be
+// attached at once, the chunks are merged
+func TestPartitionFiles(t *testing.T) {
+	database := filepath.Join(t.TempDir(), "pdns.db")
+	const days = 2*MAXATTACHED + 3
+
+	first := time.Date(2020, time.December, 20, 12, 0, 0, 0, time.Local)
+	answers := []Answer{}
+	for day := 0; day < days; day++ {
+		answers = append(answers, Answer{Time: first.AddDate(0, 0, day), Name: "www.example.com.", Class: dns.ClassINET, Type: dns.TypeA, Data: "192.0.2.1"})
+	}
+	writer := NewSqliteWriter(database, PARTITIONDAY, 1000, time.Minute)
+	if e := writer.backend.insert(answers); e != nil {
+		t.Fatal(e)
+	}
+	writer.acquire()
+	writer.release()
+
+	tests := []struct {
+		from  time.Time
+		to    time.Time
+		files int
+	}{
+		{time.Time{}, time.Time{}, days},
+		// the partitions overlapping the range
+		{first.AddDate(0, 0, 5), time.Time{}, days - 5},
+		{time.Time{}, first.AddDate(0, 0, 5), 6},
+		{first.AddDate(0, 0, 1).Add(-12 * time.Hour), first.AddDate(0, 0, 1), 1},
+	}
+	for _, test := range tests {
+		files, e := partitionFiles(database, PARTITIONDAY, test.from, test.to)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if len(files) != test.files {
+			t.Errorf("%v to %v: got %v file(s), expected %v", test.from, test.to, len(files), test.files)
+		}
+	}
+
+	store, e := OpenSqliteStore(database, PARTITIONDAY)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer store.Close()
+	records, e := store.Name("www.example.com", Query{})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(records) != 1 || records[0].Count != days || !records[0].FirstSeen.Equal(first) || !records[0].LastSeen.Equal(first.AddDate(0, 0, days-1)) {
+		t.Errorf("got %v, expected a record seen %v times from %v", records, days, first)
+	}
+	if resolutions, e := store.Resolve("www.example.com", Query{}); e != nil || len(resolutions) != 1 || resolutions[0].Count != days {
+		t.Errorf("got %v (%v), expected the address seen %v times", resolutions, e, days)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Record is a passive DNS record, aggregating all observations of the same
+// name, class, type and data.
+type Record struct {
+	Name      string
+	Class     string
+	Type      string
+	Data      string
+	Count     int64
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Query restricts a lookup to the observations within a time range (a zero
+// From or To leaves that end open) and to a set of types (none means all).
+type Query struct {
+	From  time.Time
+	To    time.Time
+	Types []string
+}
+
+// Store is the query side of a passive DNS store.
+type Store interface {
+	// Name returns the records of the name, or of the names matching a
+	// pattern (see namePattern), e.g. "*.example.com" or "www.example.*"
+	Name(name string, query Query) ([]Record, error)
+	// Data returns the records with the (exact) data, e.g. an IP address or a CNAME target
+	Data(data string, query Query) ([]Record, error)
+	// Suffix returns the records of the name and of all names below it, e.g.
+	// "example.com" matches example.com and www.example.com
+	Suffix(suffix string, query Query) ([]Record, error)
+	// Network returns the A and AAAA records with addresses in the network,
+	// e.g. 203.0.113.0/24 or 2001:db8::/32
+	Network(network *net.IPNet, query Query) ([]Record, error)
+	// Resolve returns what the name resolved to, its addresses and those of
+	// the targets of its CNAME chains (see Resolution)
+	Resolve(name string, query Query) ([]Resolution, error)
+	Close() error
+}
+
+// kinds of name patterns
+const (
+	// the name itself, e.g. "www.example.com"
+	NAMEEXACT = iota
+	// left-hand wildcard, the names below a name, e.g. "*.example.com"
+	NAMEBELOW
+	// right-hand wildcard, the names starting with labels, e.g. "www.example.*"
+	NAMEPREFIX
+)
+
+// parse a name pattern, returns the kind and the (lower cased) name without
+// the wildcard ("*" is below the root, i.e. all names). An escaped asterisk
+// ("\*") is the label itself, e.g. the name of a wildcard record
+// ("\*.example.com").
+func namePattern(pattern string) (int, string, error) {
+	name := strings.ToLower(strings.TrimRight(pattern, "."))
+	if strings.Contains(name, `\*`) {
+		if strings.Contains(strings.ReplaceAll(name, `\*`, ""), "*") {
+			return 0, "", fmt.Errorf("invalid name pattern \"%v\", expected no wildcard in a name with escaped asterisks", pattern)
+		}
+		return NAMEEXACT, strings.ReplaceAll(name, `\*`, "*"), nil
+	}
+
+	kind := NAMEEXACT
+	switch {
+	case name == "*":
+		kind, name = NAMEBELOW, ""
+	case strings.HasPrefix(name, "*."):
+		kind, name = NAMEBELOW, strings.TrimPrefix(name, "*.")
+	case strings.HasSuffix(name, ".*"):
+		kind, name = NAMEPREFIX, strings.TrimSuffix(name, ".*")
+	}
+	if strings.Contains(name, "*") {
+		return 0, "", fmt.Errorf("invalid name pattern \"%v\", expected a wildcard as the first or last label", pattern)
+	}
+	return kind, name, nil
+}
+
+// the names (or reversed names) below the prefix sort between the prefix
+// followed by "." and the prefix followed by "/" (the next character)
+func nameRange(prefix string) (string, string) {
+	return prefix + ".", prefix + "/"
+}
+
+// the address of A and AAAA data as 16 bytes (IPv4 mapped to IPv6), which
+// sort like the addresses, nil for other data
+func addressKey(rrtype string, data string) []byte {
+	if rrtype != "A" && rrtype != "AAAA" {
+		return nil
+	}
+	if ip := net.ParseIP(data).To16(); ip != nil {
+		return []byte(ip)
+	}
+	return nil
+}
+
+// the first and the last address (as 16 bytes) of the network
+func networkRange(network *net.IPNet) ([]byte, []byte) {
+	ip, mask := network.IP.To16(), network.Mask
+	if len(mask) == net.IPv4len {
+		// IPv4 mapped to IPv6
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+
+	first, last := make([]byte, net.IPv6len), make([]byte, net.IPv6len)
+	for i := 0; i < net.IPv6len; i++ {
+		first[i] = ip[i] & mask[i]
+		last[i] = ip[i] | ^mask[i]
+	}
+	return first, last
+}
+
+// merge records of the same name, class, type and data (e.g. from several
+// partitions), the result is ordered by name, type and data
+func merge(records []Record) []Record {
+	type key struct{ name, class, rrtype, data string }
+
+	merged := make(map[key]*Record, len(records))
+	order := make([]key, 0, len(records))
+	for _, record := range records {
+		k := key{record.Name, record.Class, record.Type, record.Data}
+		if existing, ok := merged[k]; ok {
+			existing.Count += record.Count
+			if record.FirstSeen.Before(existing.FirstSeen) {
+				existing.FirstSeen = record.FirstSeen
+			}
+			if existing.LastSeen.Before(record.LastSeen) {
+				existing.LastSeen = record.LastSeen
+			}
+		} else {
+			copy := record
+			merged[k] = &copy
+			order = append(order, k)
+		}
+	}
+
+	result := make([]Record, 0, len(order))
+	for _, k := range order {
+		result = append(result, *merged[k])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Data < result[j].Data
+	})
+	return result
+}
+
+// aggregate answers into records
+func aggregate(answers []Answer) []Record {
+	records := make([]Record, 0, len(answers))
+	for _, answer := range answers {
+		records = append(records, Record{
+			Name:      strings.TrimRight(answer.Name, "."),
+			Class:     dns.ClassToString[answer.Class],
+			Type:      dns.TypeToString[answer.Type],
+			Data:      strings.TrimRight(answer.Data, "."),
+			Count:     1,
+			FirstSeen: answer.Time,
+			LastSeen:  answer.Time})
+	}
+	return merge(records)
+}
+
+// types whose data is (or ends with) a name
+var NAMEDATATYPES = map[string]bool{"CNAME": true, "DNAME": true, "NS": true, "PTR": true, "MX": true, "SRV": true}
+
+// aggregate answers into records of lower cased names (and data, if a name),
+// for the stores keyed by record. Names are case insensitive and resolvers
+// randomize the case of queries (0x20), the case variants of a name are one
+// record.
+func aggregateFolded(answers []Answer) []Record {
+	records := aggregate(answers)
+	for i := range records {
+		records[i].Name = strings.ToLower(records[i].Name)
+		if NAMEDATATYPES[records[i].Type] {
+			records[i].Data = strings.ToLower(records[i].Data)
+		}
+	}
+	return merge(records)
+}
+
+// reverse the labels of a name, e.g. "www.example.com" becomes
+// "com.example.www", so the names in a domain share a prefix. The name is
+// lower cased, names are case insensitive and resolvers randomize the case
+// of queries (0x20), e.g. "WwW.ExAmPle.CoM".
+func reverse(name string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimRight(name, ".")), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
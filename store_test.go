@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func storeAnswer(seen time.Time, name string, rrtype uint16, data string) Answer {
+	return Answer{Time: seen, Name: name, Class: dns.ClassINET, Type: rrtype, Data: data}
+}
+
+// case variants (0x20) of a name are one record, the data is only lower cased
+// if a name
+func TestAggregateFolded(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	answers := []Answer{
+		storeAnswer(seen, "www.example.com.", dns.TypeA, "192.0.2.1"),
+		storeAnswer(seen.Add(time.Hour), "WwW.ExAmPle.CoM.", dns.TypeA, "192.0.2.1"),
+		storeAnswer(seen, "Web.Example.com.", dns.TypeCNAME, "WWW.example.com."),
+		storeAnswer(seen.Add(time.Minute), "web.example.com.", dns.TypeCNAME, "www.Example.com."),
+		storeAnswer(seen, "Example.com.", dns.TypeMX, "10 MAIL.example.com."),
+		storeAnswer(seen, "example.com.", dns.TypeTXT, "\"Case Sensitive\""),
+		storeAnswer(seen, "EXAMPLE.com.", dns.TypeTXT, "\"case sensitive\""),
+	}
+
+	expected := []string{
+		"example.com MX 10 mail.example.com 1",
+		"example.com TXT \"Case Sensitive\" 1",
+		"example.com TXT \"case sensitive\" 1",
+		"web.example.com CNAME www.example.com 2",
+		"www.example.com A 192.0.2.1 2",
+	}
+	records := aggregateFolded(answers)
+	found := []string{}
+	for _, record := range records {
+		found = append(found, fmt.Sprintf("%v %v %v %v", record.Name, record.Type, record.Data, record.Count))
+	}
+	if fmt.Sprint(found) != fmt.Sprint(expected) {
+		t.Fatalf("got %q, expected %q", found, expected)
+	}
+	if www := records[4]; !www.FirstSeen.Equal(seen) || !www.LastSeen.Equal(seen.Add(time.Hour)) {
+		t.Errorf("got %v - %v, expected %v - %v", www.FirstSeen, www.LastSeen, seen, seen.Add(time.Hour))
+	}
+}
+
+func TestNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		kind    int
+		name    string
+		valid   bool
+	}{
+		{"www.example.com", NAMEEXACT, "www.example.com", true},
+		{"WwW.ExAmPle.CoM.", NAMEEXACT, "www.example.com", true},
+		{"*.Example.COM", NAMEBELOW, "example.com", true},
+		{"*", NAMEBELOW, "", true},
+		{"*.", NAMEBELOW, "", true},
+		{"WWW.example.*", NAMEPREFIX, "www.example", true},
+		// the name of a wildcard record
+		{`\*.Example.com`, NAMEEXACT, "*.example.com", true},
+		{`a.\*.example.com.`, NAMEEXACT, "a.*.example.com", true},
+		{`*.\*.example.com`, 0, "", false},
+		{"www.*.com", 0, "", false},
+		{"*.example.*", 0, "", false},
+		{"w*.example.com", 0, "", false},
+	}
+	for _, test := range tests {
+		kind, name, e := namePattern(test.pattern)
+		if (e == nil) != test.valid {
+			t.Errorf("%v: got %v, expected valid %v", test.pattern, e, test.valid)
+			continue
+		}
+		if test.valid && (kind != test.kind || name != test.name) {
+			t.Errorf("%v: got %v %q, expected %v %q", test.pattern, kind, name, test.kind, test.name)
+		}
+	}
+}
+
+// the range holds the names below the prefix, not those sharing its start
+func TestNameRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		below bool
+	}{
+		{"com.example.www", true},
+		{"com.example.a.b", true},
+		{"com.example.*", true},
+		{"com.example-1.www", false},
+		{"com.example", false},
+		{"com.examples.www", false},
+		{"com.exampld.www", false},
+	}
+	low, high := nameRange(reverse("Example.COM."))
+	for _, test := range tests {
+		if below := low <= test.name && test.name < high; below != test.below {
+			t.Errorf("%v: got %v in [%q, %q), expected %v", test.name, below, low, high, test.below)
+		}
+	}
+}
+
+func TestAddressKey(t *testing.T) {
+	tests := []struct {
+		rrtype string
+		data   string
+		key    string
+	}{
+		{"A", "192.0.2.1", "00000000000000000000ffffc0000201"},
+		// IPv4 mapped, the same address
+		{"AAAA", "::ffff:192.0.2.1", "00000000000000000000ffffc0000201"},
+		{"AAAA", "2001:DB8::1", "20010db8000000000000000000000001"},
+		{"A", "192.0.2", ""},
+		{"A", "", ""},
+		{"CNAME", "192.0.2.1", ""},
+		{"PTR", "2001:db8::1", ""},
+	}
+	for _, test := range tests {
+		if key := fmt.Sprintf("%x", addressKey(test.rrtype, test.data)); key != test.key {
+			t.Errorf("%v %v: got %v, expected %v", test.rrtype, test.data, key, test.key)
+		}
+	}
+}
+
+func TestNetworkRange(t *testing.T) {
+	tests := []struct {
+		cidr  string
+		first string
+		last  string
+	}{
+		{"192.0.2.0/24", "00000000000000000000ffffc0000200", "00000000000000000000ffffc00002ff"},
+		{"192.0.2.77/24", "00000000000000000000ffffc0000200", "00000000000000000000ffffc00002ff"},
+		{"192.0.2.1/32", "00000000000000000000ffffc0000201", "00000000000000000000ffffc0000201"},
+		// the IPv4 addresses only
+		{"0.0.0.0/0", "00000000000000000000ffff00000000", "00000000000000000000ffffffffffff"},
+		// IPv4 mapped
+		{"::ffff:192.0.2.0/120", "00000000000000000000ffffc0000200", "00000000000000000000ffffc00002ff"},
+		{"2001:db8::/32", "20010db8000000000000000000000000", "20010db8ffffffffffffffffffffffff"},
+		{"2001:db8::1/128", "20010db8000000000000000000000001", "20010db8000000000000000000000001"},
+		{"::/0", "00000000000000000000000000000000", "ffffffffffffffffffffffffffffffff"},
+	}
+	for _, test := range tests {
+		_, network, e := net.ParseCIDR(test.cidr)
+		if e != nil {
+			t.Fatal(e)
+		}
+		first, last := networkRange(network)
+		if fmt.Sprintf("%x", first) != test.first || fmt.Sprintf("%x", last) != test.last {
+			t.Errorf("%v: got %x to %x, expected %v to %v", test.cidr, first, last, test.first, test.last)
+		}
+	}
+}
+
+// addresses are networks of a single address, invalid ones are errors
+func TestLookupNetwork(t *testing.T) {
+	seen := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := &networkStore{memoryStore{records: []Record{
+		{Name: "www.example.com", Class: "IN", Type: "A", Data: "192.0.2.1", Count: 1, FirstSeen: seen, LastSeen: seen},
+		{Name: "www.example.com", Class: "IN", Type: "AAAA", Data: "2001:db8::1", Count: 1, FirstSeen: seen, LastSeen: seen},
+		{Name: "mapped.example.com", Class: "IN", Type: "AAAA", Data: "::ffff:192.0.2.2", Count: 1, FirstSeen: seen, LastSeen: seen},
+	}}}
+	tests := []struct {
+		value string
+		found []string
+		valid bool
+	}{
+		{"192.0.2.1", []string{"192.0.2.1"}, true},
+		{"192.0.2.0/24", []string{"192.0.2.1", "::ffff:192.0.2.2"}, true},
+		{"::ffff:192.0.2.2", []string{"::ffff:192.0.2.2"}, true},
+		{"2001:db8::1", []string{"2001:db8::1"}, true},
+		{"2001:db8::/128", []string{}, true},
+		{"::/0", []string{"192.0.2.1", "2001:db8::1", "::ffff:192.0.2.2"}, true},
+		{"192.0.2.0/33", nil, false},
+		{"2001:db8::/129", nil, false},
+		{"192.0.2", nil, false},
+		{"www.example.com", nil, false},
+	}
+	for _, test := range tests {
+		records, e := lookupRecords(store, "ip", test.value, Query{})
+		if (e == nil) != test.valid {
+			t.Errorf("%v: got %v, expected valid %v", test.value, e, test.valid)
+			continue
+		}
+		found := []string{}
+		for _, record := range records {
+			found = append(found, record.Data)
+		}
+		if test.valid && fmt.Sprint(found) != fmt.Sprint(test.found) {
+			t.Errorf("%v: got %v, expected %v", test.value, found, test.found)
+		}
+	}
+}
+
+// networkStore looks the records up by address range like the stores
+type networkStore struct {
+	memoryStore
+}
+
+func (this *networkStore) Network(network *net.IPNet, query Query) ([]Record, error) {
+	first, last := networkRange(network)
+	return this.lookup(func(record Record) bool {
+		key := addressKey(record.Type, record.Data)
+		return key != nil && bytes.Compare(first, key) <= 0 && bytes.Compare(key, last) <= 0
+	}, query), nil
+}
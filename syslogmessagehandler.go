@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// facility local0, severity informational
+const SYSLOGPRIORITY = 16*8 + 6
+
+// enterprise number reserved for documentation (RFC 5612), used for the structured data ID
+const SYSLOGSDID = "answer@32473"
+
+// message body formats
+const (
+	SYSLOGPLAIN = "plain"
+	SYSLOGCEF   = "cef"
+	SYSLOGLEEF  = "leef"
+)
+
+// escape a structured data parameter value (RFC 5424 section 6.3.3)
+var syslogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// escape a CEF extension value
+var cefEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+// escape a CEF header value, which cannot span lines
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+
+// LEEF has no escaping, the delimiter (tab) and line breaks are replaced
+var leefEscaper = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// nor in the header, the header delimiter (|) is replaced too
+var leefHeaderEscaper = strings.NewReplacer("|", " ", "\t", " ", "\r", " ", "\n", " ")
+
+// syslogOutput sends a RFC 5424 syslog message per answer over UDP, TCP (octet
+// counting framing, RFC 6587) or a Unix socket, shared by the message handlers
+// of all workers (see forwarder).
+type syslogOutput struct {
+	format    string
+	hostname  string
+	forwarder *forwarder
+
+	mutex      *sync.Mutex
+	references int
+}
+
+// the message body in the configured format
+func (this *syslogOutput) body(answer *Answer) string {
+	name, class, rrtype, data := strings.TrimRight(answer.Name, "."), dns.ClassToString[answer.Class], dns.TypeToString[answer.Type], strings.TrimRight(answer.Data, ".")
+	query, response := "", ""
+	if answer.QueryAddress != nil {
+		query = answer.QueryAddress.String()
+	}
+	if answer.ResponseAddress != nil {
+		response = answer.ResponseAddress.String()
+	}
+
+	switch this.format {
+	case SYSLOGCEF:
+		fields := []string{
+			"rt=" + strconv.FormatInt(answer.Time.UnixNano()/int64(time.Millisecond), 10),
+			"cs1Label=rrname", "cs1=" + cefEscaper.Replace(name),
+			"cs2Label=rrtype", "cs2=" + rrtype,
+			"cs3Label=rdata", "cs3=" + cefEscaper.Replace(data),
+			"cs4Label=rrclass", "cs4=" + class,
+			"cn1Label=ttl", "cn1=" + strconv.FormatUint(uint64(answer.Ttl), 10)}
+		if answer.Sensor != "" {
+			fields = append(fields, "dvchost="+cefEscaper.Replace(answer.Sensor))
+		}
+		if query != "" {
+			fields = append(fields, "src="+query)
+		}
+		if response != "" {
+			fields = append(fields, "dst="+response)
+		}
+		return fmt.Sprintf("CEF:0|passivedns|passivedns|%s|answer|DNS answer|1|%s", cefHeaderEscaper.Replace(version.String()), strings.Join(fields, " "))
+	case SYSLOGLEEF:
+		fields := []string{
+			"devTime=" + answer.Time.Format("2006-01-02 15:04:05.000 -0700"),
+			"devTimeFormat=yyyy-MM-dd HH:mm:ss.SSS Z",
+			"rrname=" + leefEscaper.Replace(name),
+			"rrclass=" + class,
+			"rrtype=" + rrtype,
+			"rdata=" + leefEscaper.Replace(data),
+			"ttl=" + strconv.FormatUint(uint64(answer.Ttl), 10)}
+		if answer.Sensor != "" {
+			fields = append(fields, "sensor="+leefEscaper.Replace(answer.Sensor))
+		}
+		if query != "" {
+			fields = append(fields, "src="+query)
+		}
+		if response != "" {
+			fields = append(fields, "dst="+response)
+		}
+		return fmt.Sprintf("LEEF:2.0|passivedns|passivedns|%s|answer|x09|%s", leefHeaderEscaper.Replace(version.String()), strings.Join(fields, "\t"))
+	default:
+		return fmt.Sprintf("%s %s %s %s", name, class, rrtype, data)
+	}
+}
+
+// the RFC 5424 message, answers are also described as structured data
+func (this *syslogOutput) message(answer *Answer) []byte {
+	data := "-"
+	if this.format == SYSLOGPLAIN {
+		parameters := []string{
+			fmt.Sprintf(`name="%s"`, syslogEscaper.Replace(strings.TrimRight(answer.Name, "."))),
+			fmt.Sprintf(`class="%s"`, dns.ClassToString[answer.Class]),
+			fmt.Sprintf(`type="%s"`, dns.TypeToString[answer.Type]),
+			fmt.Sprintf(`data="%s"`, syslogEscaper.Replace(strings.TrimRight(answer.Data, "."))),
+			fmt.Sprintf(`ttl="%d"`, answer.Ttl)}
+		if answer.Sensor != "" {
+			parameters = append(parameters, fmt.Sprintf(`sensor="%s"`, syslogEscaper.Replace(answer.Sensor)))
+		}
+		data = "[" + SYSLOGSDID + " " + strings.Join(parameters, " ") + "]"
+	}
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s passivedns %d answer %s %s", SYSLOGPRIORITY, answer.Time.UTC().Format("2006-01-02T15:04:05.000000Z"), this.hostname, os.Getpid(), data, this.body(answer)))
+}
+
+func (this *syslogOutput) write(answers []Answer) {
+	for i := range answers {
+		this.forwarder.send(this.message(&answers[i]))
+	}
+}
+
+// syslogConnection writes messages to a syslog server, datagram sockets carry
+// a message per datagram, stream sockets need framing
+type syslogConnection struct {
+	connection net.Conn
+	writer     *bufio.Writer
+	stream     bool
+}
+
+func (this *syslogConnection) write(message []byte) error {
+	this.connection.SetWriteDeadline(time.Now().Add(RELAYTIMEOUT))
+	if !this.stream {
+		_, e := this.connection.Write(message)
+		return e
+	}
+	if _, e := fmt.Fprintf(this.writer, "%d ", len(message)); e != nil {
+		return e
+	}
+	_, e := this.writer.Write(message)
+	return e
+}
+
+func (this *syslogConnection) flush() error {
+	this.connection.SetWriteDeadline(time.Now().Add(RELAYTIMEOUT))
+	return this.writer.Flush()
+}
+
+func (this *syslogConnection) finish() {
+}
+
+func (this *syslogConnection) close() {
+	this.connection.Close()
+}
+
+func syslogConnect(network string, address string) (forwarderConnection, string, error) {
+	if network == "unix" {
+		// e.g. /dev/log is a datagram socket, others listen on a stream socket
+		if connection, e := net.DialTimeout("unixgram", address, RELAYTIMEOUT); e == nil {
+			return &syslogConnection{connection: connection, writer: bufio.NewWriter(connection)}, "unixgram", nil
+		}
+	}
+
+	connection, e := net.DialTimeout(network, address, RELAYTIMEOUT)
+	if e != nil {
+		return nil, network, e
+	}
+	return &syslogConnection{connection: connection, writer: bufio.NewWriter(connection), stream: network == "tcp" || network == "unix"}, network, nil
+}
+
+func (this *syslogOutput) acquire() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.references++
+}
+
+func (this *syslogOutput) release() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.references--; this.references == 0 {
+		this.forwarder.close()
+	}
+}
+
+// NewSyslogOutput creates the output shared by the syslog message handlers of
+// all workers, network is udp, tcp or unix and format is plain, cef or leef.
+func NewSyslogOutput(network string, address string, format string, buffer int) *syslogOutput {
+	fmt.Fprintf(os.Stderr, "Creating syslog output \"%v:%v\"\n", network, address)
+
+	hostname, e := os.Hostname()
+	if e != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	connect := func() (forwarderConnection, string, error) { return syslogConnect(network, address) }
+	return &syslogOutput{format: format, hostname: hostname, forwarder: newForwarder("Syslog", "message", network, address, buffer, connect), mutex: new(sync.Mutex)}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSyslogEscapers(t *testing.T) {
+	tests := []struct {
+		name     string
+		escaper  interface{ Replace(string) string }
+		value    string
+		expected string
+	}{
+		{"cef header", cefHeaderEscaper, `a|b\c=d`, `a\|b\\c=d`},
+		{"cef header", cefHeaderEscaper, "a\r\nb", "a  b"},
+		{"cef extension", cefEscaper, `a|b\c=d`, `a|b\\c\=d`},
+		{"cef extension", cefEscaper, "a\r\nb", `a\r\nb`},
+		{"cef extension", cefEscaper, `\=`, `\\\=`},
+		{"leef header", leefHeaderEscaper, "a|b\tc\\d=e", `a b c\d=e`},
+		{"leef extension", leefEscaper, "a|b\tc\\d=e", `a|b c\d=e`},
+		{"leef extension", leefEscaper, "a\r\nb", "a  b"},
+	}
+	for _, test := range tests {
+		if escaped := test.escaper.Replace(test.value); escaped != test.expected {
+			t.Errorf("%v %q: got %q, expected %q", test.name, test.value, escaped, test.expected)
+		}
+	}
+}
+
+// the values of an answer cannot end a field or the message
+func TestSyslogBody(t *testing.T) {
+	answer := &Answer{Time: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), Sensor: "dc=1|\\resolver\n", Name: "www.example.com.", Ttl: 300, Class: dns.ClassINET, Type: dns.TypeTXT, Data: "\"k=v|w\\\\x\"\n\"\ty\"", QueryAddress: net.ParseIP("198.51.100.1")}
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{SYSLOGCEF, "CEF:0|passivedns|passivedns|" + version.String() + "|answer|DNS answer|1|rt=1622548800000 cs1Label=rrname cs1=www.example.com cs2Label=rrtype cs2=TXT cs3Label=rdata cs3=\"k\\=v|w\\\\\\\\x\"\\n\"\ty\" cs4Label=rrclass cs4=IN cn1Label=ttl cn1=300 dvchost=dc\\=1|\\\\resolver\\n src=198.51.100.1"},
+		{SYSLOGLEEF, "LEEF:2.0|passivedns|passivedns|" + version.String() + "|answer|x09|devTime=" + answer.Time.Format("2006-01-02 15:04:05.000 -0700") + "\tdevTimeFormat=yyyy-MM-dd HH:mm:ss.SSS Z\trrname=www.example.com\trrclass=IN\trrtype=TXT\trdata=\"k=v|w\\\\x\" \" y\"\tttl=300\tsensor=dc=1|\\resolver \tsrc=198.51.100.1"},
+		{SYSLOGPLAIN, "www.example.com IN TXT \"k=v|w\\\\x\"\n\"\ty\""},
+	}
+	for _, test := range tests {
+		output := &syslogOutput{format: test.format}
+		if body := output.body(answer); body != test.expected {
+			t.Errorf("%v: got %q, expected %q", test.format, body, test.expected)
+		}
+	}
+}